@@ -0,0 +1,75 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitea.com/gitea/act_runner/internal/app/poll"
+)
+
+type adminStatus struct {
+	Draining bool `json:"draining"`
+	Running  int  `json:"running"`
+}
+
+// handleAdmin registers the admin endpoints to drain and resume poller.
+func handleAdmin(servers httpServers, addr string, poller *poll.Poller) {
+	status := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(adminStatus{
+			Draining: poller.Draining(),
+			Running:  poller.Running(),
+		})
+	}
+	post := func(action func()) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			action()
+			status(w)
+		})
+	}
+
+	servers.Handle(addr, "/admin/drain", post(poller.Drain))
+	servers.Handle(addr, "/admin/resume", post(poller.Resume))
+	servers.Handle(addr, "/admin/status", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		status(w)
+	}))
+}
+
+// watchDrainSignals drains and resumes poller on drainSignal and resumeSignal until ctx is done.
+func watchDrainSignals(ctx context.Context, poller *poll.Poller) {
+	if drainSignal == nil || resumeSignal == nil {
+		return
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, drainSignal, resumeSignal)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-c:
+				log.Infof("received signal %v", sig)
+				if sig == drainSignal {
+					poller.Drain()
+				} else {
+					poller.Resume()
+				}
+			}
+		}
+	}()
+}
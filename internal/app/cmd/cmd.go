@@ -11,6 +11,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/sandbox"
 	"gitea.com/gitea/act_runner/internal/pkg/ver"
 )
 
@@ -50,6 +51,7 @@ func Execute(ctx context.Context) {
 		RunE:  runDaemon(ctx, &daemArgs, &configFile),
 	}
 	daemonCmd.Flags().BoolVar(&daemArgs.Once, "once", false, "Run one job then exit")
+	daemonCmd.Flags().BoolVar(&daemArgs.StrictConfig, "strict-config", false, "Refuse to start, or to reload, with a config file that doesn't pass validate-config")
 	rootCmd.AddCommand(daemonCmd)
 
 	// ./act_runner exec
@@ -65,6 +67,14 @@ func Execute(ctx context.Context) {
 		},
 	})
 
+	// ./act_runner validate-config
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "validate-config",
+		Short: "Validate the config file strictly",
+		Args:  cobra.MaximumNArgs(0),
+		RunE:  runValidateConfig(&configFile),
+	})
+
 	// ./act_runner cache-server
 	var cacheArgs cacheServerArgs
 	cacheCmd := &cobra.Command{
@@ -78,6 +88,18 @@ func Execute(ctx context.Context) {
 	cacheCmd.Flags().Uint16VarP(&cacheArgs.Port, "port", "p", 0, "Port of the cache server")
 	rootCmd.AddCommand(cacheCmd)
 
+	// ./act_runner sandbox-worker
+	var workerArgs sandboxWorkerArgs
+	workerCmd := &cobra.Command{
+		Use:    sandbox.WorkerCommand,
+		Short:  "Run a job read from stdin in a sandbox, used by the daemon",
+		Args:   cobra.MaximumNArgs(0),
+		Hidden: true,
+		RunE:   runSandboxWorker(ctx, &workerArgs),
+	}
+	workerCmd.Flags().StringVar(&workerArgs.Job, "job", "", "Read the job from the file instead of stdin")
+	rootCmd.AddCommand(workerCmd)
+
 	// hide completion command
 	rootCmd.CompletionOptions.HiddenDefaultCmd = true
 
@@ -5,6 +5,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -13,6 +14,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/mattn/go-isatty"
@@ -24,13 +26,21 @@ import (
 	"gitea.com/gitea/act_runner/internal/pkg/client"
 	"gitea.com/gitea/act_runner/internal/pkg/config"
 	"gitea.com/gitea/act_runner/internal/pkg/envcheck"
+	"gitea.com/gitea/act_runner/internal/pkg/health"
 	"gitea.com/gitea/act_runner/internal/pkg/labels"
+	"gitea.com/gitea/act_runner/internal/pkg/metrics"
+	"gitea.com/gitea/act_runner/internal/pkg/reaper"
+	"gitea.com/gitea/act_runner/internal/pkg/report"
 	"gitea.com/gitea/act_runner/internal/pkg/ver"
 )
 
 func runDaemon(ctx context.Context, daemArgs *daemonArgs, configFile *string) func(cmd *cobra.Command, args []string) error {
 	return func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.LoadDefault(*configFile)
+		load := config.LoadDefault
+		if daemArgs.StrictConfig {
+			load = config.LoadStrict
+		}
+		cfg, err := load(*configFile)
 		if err != nil {
 			return fmt.Errorf("invalid configuration: %w", err)
 		}
@@ -38,6 +48,9 @@ func runDaemon(ctx context.Context, daemArgs *daemonArgs, configFile *string) fu
 		initLogging(cfg)
 		log.Infoln("Starting runner daemon")
 
+		// keep the configuration as loaded to detect changes when reloading
+		loaded := *cfg
+
 		reg, err := config.LoadRegistration(cfg.Runner.File)
 		if os.IsNotExist(err) {
 			log.Error("registration file not found, please register the runner first")
@@ -51,27 +64,43 @@ func runDaemon(ctx context.Context, daemArgs *daemonArgs, configFile *string) fu
 			lbls = cfg.Runner.Labels
 		}
 
-		ls := labels.Labels{}
-		for _, l := range lbls {
-			label, err := labels.Parse(l)
-			if err != nil {
-				log.WithError(err).Warnf("ignored invalid label %q", l)
-				continue
-			}
-			ls = append(ls, label)
-		}
+		ls := parseLabels(lbls)
 		if len(ls) == 0 {
 			log.Warn("no labels configured, runner may not be able to pick up jobs")
 		}
-
-		if ls.RequireDocker() {
-			dockerSocketPath, err := getDockerSocketPath(cfg.Container.DockerHost)
+		sets := parseLabelSets(cfg.Runner.LabelSets)
+
+		// dockerSocketPath is the Docker API socket used to run containers,
+		// it's the socket of Podman for podman labels, or empty if the runner doesn't run containers
+		var dockerSocketPath string
+		engine := containerEngine(ls.RequireDocker() || sets.RequireDocker(), ls.RequirePodman() || sets.RequirePodman())
+		switch engine {
+		case "":
+			return errors.New("docker and podman labels can't be used by the same runner")
+		case labels.SchemeDocker:
+			dockerSocketPath, err = getDockerSocketPath(cfg.Container.DockerHost)
 			if err != nil {
 				return err
 			}
 			if err := envcheck.CheckIfDockerRunning(ctx, dockerSocketPath); err != nil {
 				return err
 			}
+		case labels.SchemePodman:
+			dockerSocketPath, err = getPodmanSocketPath(cfg.Container.PodmanHost)
+			if err != nil {
+				return err
+			}
+			if err := envcheck.CheckIfPodmanRunning(ctx, dockerSocketPath); err != nil {
+				return err
+			}
+			// the pods of the jobs are created with the socket found
+			cfg.Container.PodmanHost = dockerSocketPath
+			// mount the socket of Podman to the job containers instead of docker_host
+			if cfg.Container.DockerHost != "-" {
+				cfg.Container.DockerHost = dockerSocketPath
+			}
+		}
+		if dockerSocketPath != "" {
 			// if dockerSocketPath passes the check, override DOCKER_HOST with dockerSocketPath
 			os.Setenv("DOCKER_HOST", dockerSocketPath)
 			// empty cfg.Container.DockerHost means act_runner need to find an available docker host automatically
@@ -89,6 +118,12 @@ func runDaemon(ctx context.Context, daemArgs *daemonArgs, configFile *string) fu
 			}
 		}
 
+		if ls.Require(labels.SchemeLXC) || sets.Require(labels.SchemeLXC) {
+			if err := envcheck.CheckIfLXCRunning(ctx, cfg.LXC.Command); err != nil {
+				return err
+			}
+		}
+
 		if !slices.Equal(reg.Labels, ls.ToStrings()) {
 			reg.Labels = ls.ToStrings()
 			if err := config.SaveRegistration(cfg.Runner.File, reg); err != nil {
@@ -105,7 +140,16 @@ func runDaemon(ctx context.Context, daemArgs *daemonArgs, configFile *string) fu
 			ver.Version(),
 		)
 
-		runner := run.NewRunner(cfg, reg, cli)
+		runner := run.NewRunner(cfg, reg, cli, sets)
+		runner.SweepWorkdirs()
+		go report.RunSpoolReplayer(ctx, cli, cfg.Runner.SpoolDir, runner.Running, time.Minute)
+		if dockerSocketPath != "" && *cfg.Container.Reaper.Enabled {
+			rp, err := reaper.NewFromEnv(reg.UUID, cfg.Container.Egress.Iptables, cfg.Container.Reaper.GracePeriod, runner.Running)
+			if err != nil {
+				return fmt.Errorf("failed to create the reaper: %w", err)
+			}
+			go rp.Run(ctx, cfg.Container.Reaper.Interval)
+		}
 
 		// declare the labels of the runner before fetching tasks
 		resp, err := runner.Declare(ctx, ls.Names())
@@ -122,6 +166,37 @@ func runDaemon(ctx context.Context, daemArgs *daemonArgs, configFile *string) fu
 
 		poller := poll.New(cfg, cli, runner)
 
+		servers := httpServers{}
+		if cfg.Metrics.Enabled {
+			servers.Handle(cfg.Metrics.Addr, "/metrics", metrics.Handler())
+		}
+		var checker *health.Checker
+		if cfg.Health.Enabled {
+			checker = health.NewChecker(poller, maxFetchAge(cfg), dockerSocketPath)
+			checker.SetDeclared(true)
+			servers.Handle(cfg.Health.Addr, "/healthz", checker.LiveHandler())
+			servers.Handle(cfg.Health.Addr, "/readyz", checker.ReadyHandler())
+		}
+		if cfg.Admin.Enabled {
+			handleAdmin(servers, cfg.Admin.Addr, poller)
+		}
+		servers.Serve(ctx)
+		watchDrainSignals(ctx, poller)
+
+		rl := &reloader{
+			file:    *configFile,
+			load:    load,
+			loaded:  &loaded,
+			running: cfg,
+			reg:     reg,
+			labels:  ls,
+			engine:  engine,
+			runner:  runner,
+			poller:  poller,
+			checker: checker,
+		}
+		go rl.Watch(ctx)
+
 		if daemArgs.Once {
 			done := make(chan struct{})
 			go func() {
@@ -140,6 +215,7 @@ func runDaemon(ctx context.Context, daemArgs *daemonArgs, configFile *string) fu
 			<-ctx.Done()
 		}
 
+		cfg = rl.Config()
 		log.Infof("runner: %s shutdown initiated, waiting %s for running jobs to complete before shutting down", resp.Msg.Runner.Name, cfg.Runner.ShutdownTimeout)
 
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.Runner.ShutdownTimeout)
@@ -154,8 +230,37 @@ func runDaemon(ctx context.Context, daemArgs *daemonArgs, configFile *string) fu
 	}
 }
 
+// parseLabels parses lbls, invalid labels are ignored.
+func parseLabels(lbls []string) labels.Labels {
+	ls := labels.Labels{}
+	for _, l := range lbls {
+		label, err := labels.Parse(l)
+		if err != nil {
+			log.WithError(err).Warnf("ignored invalid label %q", l)
+			continue
+		}
+		ls = append(ls, label)
+	}
+	return ls
+}
+
+// parseLabelSets parses the label sets of the configuration, invalid sets are ignored.
+func parseLabelSets(cfgSets []config.LabelSet) labels.Sets {
+	sets := labels.Sets{}
+	for _, s := range cfgSets {
+		set, err := labels.ParseSet(s.Labels, s.Platform)
+		if err != nil {
+			log.WithError(err).Warnf("ignored invalid label set %v", s.Labels)
+			continue
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
 type daemonArgs struct {
-	Once bool
+	Once         bool
+	StrictConfig bool
 }
 
 // initLogging setup the global logrus logger.
@@ -228,3 +333,43 @@ func getDockerSocketPath(configDockerHost string) (string, error) {
 
 	return "", fmt.Errorf("daemon Docker Engine socket not found and docker_host config was invalid")
 }
+
+// containerEngine returns the scheme of the container engine required by labels,
+// labels.SchemeHost if none is required, or "" if both docker and podman are required.
+func containerEngine(docker, podman bool) string {
+	switch {
+	case docker && podman:
+		return ""
+	case docker:
+		return labels.SchemeDocker
+	case podman:
+		return labels.SchemePodman
+	default:
+		return labels.SchemeHost
+	}
+}
+
+var podmanSocketPaths = []string{
+	"$XDG_RUNTIME_DIR/podman/podman.sock",
+	"/run/podman/podman.sock",
+}
+
+// getPodmanSocketPath returns the API socket of Podman, the rootless one is preferred.
+func getPodmanSocketPath(configPodmanHost string) (string, error) {
+	if configPodmanHost != "" {
+		return configPodmanHost, nil
+	}
+
+	socket, found := os.LookupEnv("CONTAINER_HOST")
+	if found {
+		return socket, nil
+	}
+
+	for _, p := range podmanSocketPaths {
+		if _, err := os.Lstat(os.ExpandEnv(p)); err == nil {
+			return "unix://" + filepath.ToSlash(os.ExpandEnv(p)), nil
+		}
+	}
+
+	return "", fmt.Errorf("podman API socket not found and podman_host config was invalid, is `podman system service` running?")
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// httpServers groups the HTTP endpoints of the daemon by listen address,
+// so endpoints configured with the same address share one listener.
+type httpServers map[string]*http.ServeMux
+
+func (s httpServers) Handle(addr, pattern string, handler http.Handler) {
+	mux, ok := s[addr]
+	if !ok {
+		mux = http.NewServeMux()
+		s[addr] = mux
+	}
+	mux.Handle(pattern, handler)
+}
+
+// Serve starts serving all the endpoints until ctx is done.
+func (s httpServers) Serve(ctx context.Context) {
+	for addr, mux := range s {
+		go serveHTTP(ctx, addr, mux)
+	}
+}
+
+// serveHTTP serves handler on addr until ctx is done.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("http server is listening on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.WithError(err).Errorf("http server on %s stopped", addr)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitea.com/gitea/act_runner/internal/app/poll"
+	"gitea.com/gitea/act_runner/internal/app/run"
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/health"
+	"gitea.com/gitea/act_runner/internal/pkg/labels"
+)
+
+// configWatchInterval is the interval to check the configuration file for changes.
+const configWatchInterval = 5 * time.Second
+
+// reloader applies changes of the configuration file to a running daemon.
+type reloader struct {
+	mu sync.Mutex
+
+	file    string
+	load    func(file string) (*config.Config, error)
+	loaded  *config.Config // loaded is the configuration as it has been loaded from file
+	running *config.Config // running is the configuration in effect, including adjustments made by the daemon
+	reg     *config.Registration
+	labels  labels.Labels
+	engine  string // engine is the container engine the daemon has been set up for, see containerEngine
+
+	runner  *run.Runner
+	poller  *poll.Poller
+	checker *health.Checker // checker is nil if the health endpoints are disabled
+}
+
+// Config returns the configuration in effect.
+func (rl *reloader) Config() *config.Config {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.running
+}
+
+// Reload loads the configuration file again and applies it.
+// Fields which can't be changed without a restart keep their values, and so do the labels if they can't be declared.
+func (rl *reloader) Reload(ctx context.Context) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	next, err := rl.load(rl.file)
+	if err != nil {
+		log.WithError(err).Error("failed to reload configuration, keep the running one")
+		return
+	}
+	loaded := *next
+
+	for _, name := range config.RestartRequired(rl.loaded, next) {
+		log.Errorf("configuration %q can't be changed without restarting the runner, the change has been ignored", name)
+	}
+	config.KeepRestartRequired(rl.running, next)
+
+	ls := rl.labels
+	if len(next.Runner.Labels) > 0 {
+		if parsed := parseLabels(next.Runner.Labels); !slices.Equal(parsed.ToStrings(), ls.ToStrings()) {
+			if rl.declare(ctx, parsed) {
+				ls = parsed
+			}
+		}
+	}
+
+	sets := parseLabelSets(next.Runner.LabelSets)
+	if !rl.engineReady(sets.RequireDocker(), sets.RequirePodman()) {
+		log.Error("the reloaded label sets require a container engine which hasn't been set up, please restart the runner to use them")
+		sets = parseLabelSets(rl.running.Runner.LabelSets)
+		next.Runner.LabelSets = rl.running.Runner.LabelSets
+	}
+
+	initLogging(next)
+	rl.runner.Update(next, ls, sets)
+	rl.poller.Update(next)
+	if rl.checker != nil {
+		rl.checker.SetMaxFetchAge(maxFetchAge(next))
+	}
+
+	// keep comparing with the original values, so ignored changes are reported until they're reverted
+	config.KeepRestartRequired(rl.loaded, &loaded)
+	rl.loaded = &loaded
+	rl.running = next
+	rl.labels = ls
+	log.Infof("configuration reloaded")
+}
+
+// declare declares ls and saves them to the registration file, it returns false if ls can't be used.
+func (rl *reloader) declare(ctx context.Context, ls labels.Labels) bool {
+	if len(ls) == 0 {
+		log.Error("no valid labels in the reloaded configuration, keep the running labels")
+		return false
+	}
+	if !rl.engineReady(ls.RequireDocker(), ls.RequirePodman()) {
+		log.Error("the reloaded labels require a container engine which hasn't been set up, please restart the runner to use them")
+		return false
+	}
+
+	if _, err := rl.runner.Declare(ctx, ls.Names()); err != nil {
+		log.WithError(err).Error("failed to declare the reloaded labels, keep the running labels")
+		return false
+	}
+
+	rl.reg.Labels = ls.ToStrings()
+	if err := config.SaveRegistration(rl.running.Runner.File, rl.reg); err != nil {
+		log.WithError(err).Error("failed to save runner config")
+	}
+	log.Infof("labels updated to: %v", rl.reg.Labels)
+	return true
+}
+
+// engineReady reports whether the container engine required by labels has been set up.
+func (rl *reloader) engineReady(docker, podman bool) bool {
+	return (!docker || rl.engine == labels.SchemeDocker) && (!podman || rl.engine == labels.SchemePodman)
+}
+
+// Watch reloads the configuration on reloadSignal or when the configuration file changes, until ctx is done.
+func (rl *reloader) Watch(ctx context.Context) {
+	c := make(chan os.Signal, 1)
+	if reloadSignal != nil {
+		signal.Notify(c, reloadSignal)
+	}
+
+	var ticker <-chan time.Time
+	last, _ := os.Stat(rl.file)
+	if rl.file != "" {
+		t := time.NewTicker(configWatchInterval)
+		defer t.Stop()
+		ticker = t.C
+	}
+
+	defer signal.Stop(c)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-c:
+			log.Infof("received signal %v, reloading configuration", sig)
+			rl.Reload(ctx)
+		case <-ticker:
+			stat, err := os.Stat(rl.file)
+			if err != nil || (last != nil && stat.ModTime().Equal(last.ModTime()) && stat.Size() == last.Size()) {
+				continue
+			}
+			last = stat
+			log.Infof("configuration file %q changed, reloading configuration", rl.file)
+			rl.Reload(ctx)
+		}
+	}
+}
+
+// maxFetchAge returns how old the last successful fetch may be for the runner to be ready.
+func maxFetchAge(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.Health.FetchIntervals)*cfg.Runner.FetchInterval + cfg.Runner.FetchTimeout
+}
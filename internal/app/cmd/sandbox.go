@@ -0,0 +1,38 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"gitea.com/gitea/act_runner/internal/app/run"
+)
+
+type sandboxWorkerArgs struct {
+	Job string
+}
+
+// runSandboxWorker runs a job in a sandbox, it's started by the daemon after copying the runner into the sandbox.
+func runSandboxWorker(ctx context.Context, workerArgs *sandboxWorkerArgs) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		var job io.Reader = os.Stdin
+		if workerArgs.Job != "" {
+			f, err := os.Open(workerArgs.Job)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			job = f
+		}
+
+		// stdout is reserved for the events read by the daemon
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		return run.RunSandboxWorker(ctx, job, stdout)
+	}
+}
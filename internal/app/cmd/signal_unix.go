@@ -0,0 +1,20 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// drainSignal and resumeSignal drain and resume the runner daemon.
+var (
+	drainSignal  os.Signal = syscall.SIGUSR1
+	resumeSignal os.Signal = syscall.SIGUSR2
+)
+
+// reloadSignal reloads the configuration of the runner daemon.
+var reloadSignal os.Signal = syscall.SIGHUP
@@ -0,0 +1,17 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+//go:build windows
+
+package cmd
+
+import "os"
+
+// drainSignal and resumeSignal are not supported on Windows, use the admin endpoints instead.
+var (
+	drainSignal  os.Signal
+	resumeSignal os.Signal
+)
+
+// reloadSignal is not supported on Windows, the configuration file is watched for changes instead.
+var reloadSignal os.Signal
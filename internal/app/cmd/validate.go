@@ -0,0 +1,40 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+// runValidateConfig validates the config file and prints every problem found with its position in the file.
+func runValidateConfig(configFile *string) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if *configFile == "" {
+			return fmt.Errorf("no config file specified, please use -c/--config")
+		}
+
+		err := config.Validate(*configFile)
+		var validationErrs config.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			for _, e := range validationErrs {
+				if e.Line > 0 {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s:%d:%d: %s: %s\n", *configFile, e.Line, e.Column, e.Field, e.Message)
+				} else {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s: %s: %s\n", *configFile, e.Field, e.Message)
+				}
+			}
+			return fmt.Errorf("%d problem(s) found in %s", len(validationErrs), *configFile)
+		} else if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "%s is valid\n", *configFile)
+		return nil
+	}
+}
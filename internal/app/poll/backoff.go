@@ -0,0 +1,95 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package poll
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+// backoff delays FetchTask requests after consecutive errors.
+type backoff struct {
+	mu       sync.Mutex
+	base     time.Duration
+	max      time.Duration
+	failures map[connect.Code]int // failures counts the consecutive errors, partitioned by connect error code
+	until    time.Time
+	jitter   func() float64 // jitter returns a random number in [0, 1)
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{
+		base:     base,
+		max:      max,
+		failures: map[connect.Code]int{},
+		jitter:   rand.Float64,
+	}
+}
+
+// SetLimits changes the delay after the first error and the maximum delay.
+func (b *backoff) SetLimits(base, max time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.base = base
+	b.max = max
+}
+
+// Fail records an error with code and returns the delay before the next request.
+// The delay doubles with every consecutive error with the same code, up to max,
+// and is randomized to spread the requests of many runners.
+func (b *backoff) Fail(code connect.Code) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures[code]++
+	delay := b.base
+	for i := 1; i < b.failures[code] && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	// use "equal jitter", the delay is in [delay/2, delay)
+	delay = delay/2 + time.Duration(b.jitter()*float64(delay/2))
+
+	if until := time.Now().Add(delay); until.After(b.until) {
+		b.until = until
+	}
+	return delay
+}
+
+// Succeed resets the backoff after a successful request.
+func (b *backoff) Succeed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	clear(b.failures)
+	b.until = time.Time{}
+}
+
+// Wait blocks until the delay of the last error has passed.
+func (b *backoff) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		d := time.Until(b.until)
+		b.mu.Unlock()
+
+		// another goroutine may have extended the delay meanwhile, so check again after waiting
+		if d <= 0 {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package poll
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff_Fail(t *testing.T) {
+	b := newBackoff(2*time.Second, 10*time.Second)
+	b.jitter = func() float64 { return 0.5 }
+
+	// equal jitter with 0.5 returns 3/4 of the full delay
+	assert.Equal(t, 1500*time.Millisecond, b.Fail(connect.CodeUnavailable))
+	assert.Equal(t, 3*time.Second, b.Fail(connect.CodeUnavailable))
+	assert.Equal(t, 6*time.Second, b.Fail(connect.CodeUnavailable))
+	assert.Equal(t, 7500*time.Millisecond, b.Fail(connect.CodeUnavailable))
+	assert.Equal(t, 7500*time.Millisecond, b.Fail(connect.CodeUnavailable))
+
+	// errors with another code are counted separately
+	assert.Equal(t, 1500*time.Millisecond, b.Fail(connect.CodeUnauthenticated))
+
+	b.Succeed()
+	assert.Equal(t, 1500*time.Millisecond, b.Fail(connect.CodeUnavailable))
+}
+
+func TestBackoff_Wait(t *testing.T) {
+	b := newBackoff(time.Hour, time.Hour)
+	require.NoError(t, b.Wait(context.Background()))
+
+	b.Fail(connect.CodeUnavailable)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Wait(ctx), context.DeadlineExceeded)
+
+	b.Succeed()
+	require.NoError(t, b.Wait(context.Background()))
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
 	"connectrpc.com/connect"
@@ -18,13 +19,19 @@ import (
 	"gitea.com/gitea/act_runner/internal/app/run"
 	"gitea.com/gitea/act_runner/internal/pkg/client"
 	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/metrics"
 )
 
 type Poller struct {
 	client       client.Client
 	runner       *run.Runner
-	cfg          *config.Config
+	limiter      *rate.Limiter
+	fetchTimeout atomic.Int64 // fetchTimeout stores the timeout of a FetchTask request.
 	tasksVersion atomic.Int64 // tasksVersion used to store the version of the last task fetched from the Gitea.
+	lastFetch    atomic.Int64 // lastFetch stores the unix nano time of the last successful fetch.
+	backoff      *backoff     // backoff delays fetching after consecutive errors.
+	running      atomic.Int64 // running counts the tasks being run.
+	capacity     atomic.Int64 // capacity limits the tasks to be run concurrently.
 
 	pollingCtx      context.Context
 	shutdownPolling context.CancelFunc
@@ -32,6 +39,11 @@ type Poller struct {
 	jobsCtx      context.Context
 	shutdownJobs context.CancelFunc
 
+	drainMu sync.Mutex
+	resumed chan struct{} // resumed is closed unless the poller is draining.
+
+	workersChanged chan struct{} // workersChanged is signaled when a worker is released or the capacity changes.
+
 	done chan struct{}
 }
 
@@ -42,10 +54,15 @@ func New(cfg *config.Config, client client.Client, runner *run.Runner) *Poller {
 
 	done := make(chan struct{})
 
-	return &Poller{
-		client: client,
-		runner: runner,
-		cfg:    cfg,
+	resumed := make(chan struct{})
+	close(resumed)
+
+	p := &Poller{
+		client:  client,
+		runner:  runner,
+		limiter: rate.NewLimiter(rate.Every(cfg.Runner.FetchInterval), 1),
+
+		backoff: newBackoff(cfg.Runner.FetchInterval, cfg.Runner.FetchBackoffMax),
 
 		pollingCtx:      pollingCtx,
 		shutdownPolling: shutdownPolling,
@@ -53,16 +70,43 @@ func New(cfg *config.Config, client client.Client, runner *run.Runner) *Poller {
 		jobsCtx:      jobsCtx,
 		shutdownJobs: shutdownJobs,
 
+		resumed: resumed,
+
+		workersChanged: make(chan struct{}, 1),
+
 		done: done,
 	}
+	p.capacity.Store(int64(cfg.Runner.Capacity))
+	p.fetchTimeout.Store(int64(cfg.Runner.FetchTimeout))
+
+	return p
+}
+
+// Update applies the capacity and the fetch settings of cfg.
+func (p *Poller) Update(cfg *config.Config) {
+	p.fetchTimeout.Store(int64(cfg.Runner.FetchTimeout))
+	p.limiter.SetLimit(rate.Every(cfg.Runner.FetchInterval))
+	p.backoff.SetLimits(cfg.Runner.FetchInterval, cfg.Runner.FetchBackoffMax)
+	p.SetCapacity(cfg.Runner.Capacity)
 }
 
+// Poll fetches tasks in a single loop and runs them in a pool of workers.
+// A task is only fetched while a worker is free, so an idle runner sends the same requests regardless of its capacity.
 func (p *Poller) Poll() {
-	limiter := rate.NewLimiter(rate.Every(p.cfg.Runner.FetchInterval), 1)
 	wg := &sync.WaitGroup{}
-	for i := 0; i < p.cfg.Runner.Capacity; i++ {
+	for {
+		task, ok := p.fetchNext()
+		if !ok {
+			break
+		}
+
 		wg.Add(1)
-		go p.poll(wg, limiter)
+		p.running.Add(1)
+		go func() {
+			defer wg.Done()
+			defer p.releaseWorker()
+			p.runTaskWithRecover(p.jobsCtx, task)
+		}()
 	}
 	wg.Wait()
 
@@ -71,9 +115,11 @@ func (p *Poller) Poll() {
 }
 
 func (p *Poller) PollOnce() {
-	limiter := rate.NewLimiter(rate.Every(p.cfg.Runner.FetchInterval), 1)
-
-	p.pollOnce(limiter)
+	if task, ok := p.fetchNext(); ok {
+		p.running.Add(1)
+		p.runTaskWithRecover(p.jobsCtx, task)
+		p.releaseWorker()
+	}
 
 	// signal that we're done
 	close(p.done)
@@ -108,36 +154,140 @@ func (p *Poller) Shutdown(ctx context.Context) error {
 	}
 }
 
-func (p *Poller) poll(wg *sync.WaitGroup, limiter *rate.Limiter) {
-	defer wg.Done()
+// fetchNext waits for a free worker and fetches until a task is got.
+// It returns false if polling has been shut down.
+func (p *Poller) fetchNext() (*runnerv1.Task, bool) {
 	for {
-		p.pollOnce(limiter)
-
-		select {
-		case <-p.pollingCtx.Done():
-			return
-		default:
-			continue
+		if err := p.waitWorker(p.pollingCtx); err != nil {
+			return nil, false
 		}
-	}
-}
-
-func (p *Poller) pollOnce(limiter *rate.Limiter) {
-	for {
-		if err := limiter.Wait(p.pollingCtx); err != nil {
+		if err := p.waitResumed(p.pollingCtx); err != nil {
+			return nil, false
+		}
+		if err := p.backoff.Wait(p.pollingCtx); err != nil {
+			return nil, false
+		}
+		if err := p.limiter.Wait(p.pollingCtx); err != nil {
 			if p.pollingCtx.Err() != nil {
 				log.WithError(err).Debug("limiter wait failed")
 			}
-			return
+			return nil, false
 		}
-		task, ok := p.fetchTask(p.pollingCtx)
-		if !ok {
+		if p.Draining() {
 			continue
 		}
+		if task, ok := p.fetchTask(p.pollingCtx); ok {
+			return task, true
+		}
+	}
+}
 
-		p.runTaskWithRecover(p.jobsCtx, task)
-		return
+// SetCapacity changes the number of tasks that can be run concurrently.
+// Running tasks are not affected if the capacity is reduced.
+func (p *Poller) SetCapacity(capacity int) {
+	p.capacity.Store(int64(capacity))
+	p.notifyWorkers()
+}
+
+// Capacity returns the number of tasks that can be run concurrently.
+func (p *Poller) Capacity() int {
+	return int(p.capacity.Load())
+}
+
+// waitWorker blocks until the number of running tasks is below the capacity.
+func (p *Poller) waitWorker(ctx context.Context) error {
+	for p.running.Load() >= p.capacity.Load() {
+		select {
+		case <-p.workersChanged:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+	return nil
+}
+
+func (p *Poller) releaseWorker() {
+	p.running.Add(-1)
+	p.notifyWorkers()
+}
+
+func (p *Poller) notifyWorkers() {
+	select {
+	case p.workersChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Drain stops fetching new tasks, running tasks are not affected.
+func (p *Poller) Drain() {
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+
+	select {
+	case <-p.resumed:
+		p.resumed = make(chan struct{})
+		metrics.Draining.Set(1)
+		log.Infof("runner is draining, no new tasks will be fetched")
+	default:
+	}
+}
+
+// Resume starts fetching new tasks again after Drain.
+func (p *Poller) Resume() {
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+
+	select {
+	case <-p.resumed:
+	default:
+		close(p.resumed)
+		metrics.Draining.Set(0)
+		log.Infof("runner resumed fetching tasks")
+	}
+}
+
+// Draining reports whether the poller is draining.
+func (p *Poller) Draining() bool {
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+
+	select {
+	case <-p.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Running returns the number of tasks being run.
+func (p *Poller) Running() int {
+	return int(p.running.Load())
+}
+
+func (p *Poller) waitResumed(ctx context.Context) error {
+	p.drainMu.Lock()
+	resumed := p.resumed
+	p.drainMu.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LastFetch returns the time of the last successful FetchTask request.
+func (p *Poller) LastFetch() time.Time {
+	if v := p.lastFetch.Load(); v != 0 {
+		return time.Unix(0, v)
+	}
+	return time.Time{}
+}
+
+// Busy reports whether all the capacity is in use.
+func (p *Poller) Busy() bool {
+	return p.running.Load() >= p.capacity.Load()
 }
 
 func (p *Poller) runTaskWithRecover(ctx context.Context, task *runnerv1.Task) {
@@ -154,7 +304,7 @@ func (p *Poller) runTaskWithRecover(ctx context.Context, task *runnerv1.Task) {
 }
 
 func (p *Poller) fetchTask(ctx context.Context) (*runnerv1.Task, bool) {
-	reqCtx, cancel := context.WithTimeout(ctx, p.cfg.Runner.FetchTimeout)
+	reqCtx, cancel := context.WithTimeout(ctx, time.Duration(p.fetchTimeout.Load()))
 	defer cancel()
 
 	// Load the version value that was in the cache when the request was sent.
@@ -165,14 +315,20 @@ func (p *Poller) fetchTask(ctx context.Context) (*runnerv1.Task, bool) {
 	if errors.Is(err, context.DeadlineExceeded) {
 		err = nil
 	}
+	metrics.FetchTaskTotal.Inc()
 	if err != nil {
-		log.WithError(err).Error("failed to fetch task")
+		code := connect.CodeOf(err)
+		metrics.FetchTaskErrorsTotal.WithLabelValues(code.String()).Inc()
+		delay := p.backoff.Fail(code)
+		log.WithError(err).Errorf("failed to fetch task, retry in %s", delay.Truncate(time.Millisecond))
 		return nil, false
 	}
 
 	if resp == nil || resp.Msg == nil {
 		return nil, false
 	}
+	p.lastFetch.Store(time.Now().UnixNano())
+	p.backoff.Succeed()
 
 	if resp.Msg.TasksVersion > v {
 		p.tasksVersion.CompareAndSwap(v, resp.Msg.TasksVersion)
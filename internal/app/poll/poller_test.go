@@ -0,0 +1,138 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package poll
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitea.com/gitea/act_runner/internal/pkg/client/mocks"
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+func newTestPoller(t *testing.T, cli *mocks.Client, capacity int) *Poller {
+	p := New(&config.Config{Runner: config.Runner{
+		Capacity:        capacity,
+		FetchTimeout:    time.Second,
+		FetchInterval:   time.Millisecond,
+		FetchBackoffMax: time.Millisecond,
+	}}, cli, nil)
+	t.Cleanup(p.shutdownPolling)
+	return p
+}
+
+// blocked reports whether wait is still blocked after a short while.
+func blocked(wait func(ctx context.Context) error) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	return errors.Is(wait(ctx), context.DeadlineExceeded)
+}
+
+func TestPoller_waitWorker(t *testing.T) {
+	p := newTestPoller(t, mocks.NewClient(t), 1)
+	assert.False(t, p.Busy())
+	require.NoError(t, p.waitWorker(context.Background()))
+
+	p.running.Add(1)
+	assert.True(t, p.Busy())
+	assert.True(t, blocked(p.waitWorker))
+
+	// raising the capacity frees a worker
+	done := make(chan error)
+	go func() { done <- p.waitWorker(context.Background()) }()
+	p.SetCapacity(2)
+	require.NoError(t, <-done)
+	assert.Equal(t, 2, p.Capacity())
+
+	// reducing the capacity doesn't affect running tasks, but blocks until one is released
+	p.running.Add(1)
+	p.SetCapacity(1)
+	assert.Equal(t, 2, p.Running())
+	assert.True(t, blocked(p.waitWorker))
+	p.releaseWorker()
+	assert.True(t, blocked(p.waitWorker))
+	go func() { done <- p.waitWorker(context.Background()) }()
+	p.releaseWorker()
+	require.NoError(t, <-done)
+	assert.Equal(t, 0, p.Running())
+}
+
+func TestPoller_Drain(t *testing.T) {
+	p := newTestPoller(t, mocks.NewClient(t), 1)
+	assert.False(t, p.Draining())
+	require.NoError(t, p.waitResumed(context.Background()))
+
+	p.Drain()
+	p.Drain()
+	assert.True(t, p.Draining())
+	assert.True(t, blocked(p.waitResumed))
+
+	done := make(chan error)
+	go func() { done <- p.waitResumed(context.Background()) }()
+	p.Resume()
+	require.NoError(t, <-done)
+	assert.False(t, p.Draining())
+	p.Resume()
+	assert.False(t, p.Draining())
+}
+
+func TestPoller_fetchNext(t *testing.T) {
+	var versions []int64
+	responses := []*runnerv1.FetchTaskResponse{
+		{TasksVersion: 3},
+		nil,
+		{TasksVersion: 4, Task: &runnerv1.Task{Id: 42}},
+	}
+	cli := mocks.NewClient(t)
+	cli.On("FetchTask", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect.Request[runnerv1.FetchTaskRequest]) (*connect.Response[runnerv1.FetchTaskResponse], error) {
+		versions = append(versions, req.Msg.TasksVersion)
+		resp := responses[0]
+		responses = responses[1:]
+		if resp == nil {
+			return nil, connect.NewError(connect.CodeUnavailable, errors.New("unavailable"))
+		}
+		return connect.NewResponse(resp), nil
+	})
+
+	p := newTestPoller(t, cli, 1)
+	task, ok := p.fetchNext()
+	require.True(t, ok)
+	assert.EqualValues(t, 42, task.Id)
+	assert.Equal(t, []int64{0, 3, 3}, versions)
+	assert.EqualValues(t, 0, p.tasksVersion.Load(), "the version is reset once a task is got")
+	assert.WithinDuration(t, time.Now(), p.LastFetch(), time.Second)
+
+	// no task is fetched while all workers are busy or after shutdown
+	p.running.Add(1)
+	go p.shutdownPolling()
+	_, ok = p.fetchNext()
+	assert.False(t, ok)
+}
+
+func TestPoller_fetchNext_Draining(t *testing.T) {
+	p := newTestPoller(t, mocks.NewClient(t), 1)
+	assert.True(t, p.LastFetch().IsZero())
+	p.Drain()
+
+	done := make(chan bool)
+	go func() {
+		_, ok := p.fetchNext()
+		done <- ok
+	}()
+	select {
+	case <-done:
+		t.Fatal("a draining poller must not fetch tasks")
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.shutdownPolling()
+	assert.False(t, <-done)
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/egress"
+	"gitea.com/gitea/act_runner/internal/pkg/labels"
+	"gitea.com/gitea/act_runner/internal/pkg/reaper"
+)
+
+// createEgressNetwork creates the network of task whose egress is restricted by the policy of containerCfg.
+// It is labeled with owner, the uuid of the runner, so the reaper can remove it with its chain.
+// The Gitea instance at address and the cache server in envs are always allowed.
+func createEgressNetwork(ctx context.Context, task *runnerv1.Task, containerCfg config.Container, label *labels.Label, envs map[string]string, address, owner string) (*egress.Network, error) {
+	if label.Schema != labels.SchemeDocker {
+		return nil, fmt.Errorf("the egress policy isn't supported by %s labels", label.Schema)
+	}
+	if containerCfg.Network != "" {
+		return nil, fmt.Errorf("the egress policy can't be used with network %q", containerCfg.Network)
+	}
+
+	allow := append([]string{}, containerCfg.Egress.Allow...)
+	for _, v := range []string{address, envs["ACTIONS_CACHE_URL"]} {
+		if u, err := url.Parse(v); err == nil && u.Hostname() != "" {
+			allow = append(allow, u.Hostname())
+		}
+	}
+	prefixes, err := egress.Resolve(ctx, allow)
+	if err != nil {
+		return nil, err
+	}
+	return egress.Create(ctx, containerCfg.Egress.Iptables, fmt.Sprintf("%s%d-network", reaper.NamePrefix, task.Id), ownerLabels(owner), prefixes)
+}
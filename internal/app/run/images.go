@@ -0,0 +1,199 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/nektos/act/pkg/exprparser"
+	"github.com/nektos/act/pkg/jobparser"
+	"github.com/nektos/act/pkg/model"
+	log "github.com/sirupsen/logrus"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/imagepolicy"
+)
+
+// expressionPattern matches the expressions in a string, like "${{ matrix.image }}".
+var expressionPattern = regexp.MustCompile(`\$\{\{(.*?)\}\}`)
+
+// checkImages checks the images used by job against policy before the job starts, and returns all the violations.
+// The images of docker actions are only known after the job starts, so they're checked by imageHook.
+func checkImages(task *runnerv1.Task, jobID string, job *model.Job, policy config.ImagePolicy) error {
+	p, err := imagepolicy.New(policy.Allow, policy.Deny, policy.RequireDigest)
+	if err != nil {
+		return err
+	}
+	if !p.Enabled() {
+		return nil
+	}
+
+	var images []string
+	if c := job.Container(); c != nil && c.Image != "" {
+		images = append(images, c.Image)
+	}
+	names := make([]string, 0, len(job.Services))
+	for name := range job.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if s := job.Services[name]; s != nil && s.Image != "" {
+			images = append(images, s.Image)
+		}
+	}
+	for _, step := range job.Steps {
+		if image, ok := strings.CutPrefix(step.Uses, "docker://"); ok {
+			images = append(images, image)
+		}
+	}
+
+	interpreter := newJobInterpreter(task, jobID, job)
+	var violations []string
+	for _, image := range images {
+		resolved, err := interpolateImage(interpreter, image)
+		if err == nil {
+			err = p.Check(resolved)
+		}
+		if err != nil {
+			violations = append(violations, err.Error())
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("the image policy of the runner rejects the job: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+var (
+	// dockerImagePattern matches the messages act logs before pulling an image or creating a container from it.
+	dockerImagePattern = regexp.MustCompile(`docker (?:pull|create) image=(\S+) platform=`)
+	// dockerBuildPattern matches the message act logs before building the image of a docker action from its Dockerfile.
+	dockerBuildPattern = regexp.MustCompile(`docker build -t (\S+)`)
+	// builtImagePattern matches the tags of the images act builds for docker actions, they may have been built by a previous job.
+	builtImagePattern = regexp.MustCompile(`^act-.*-dockeraction:latest$`)
+)
+
+// imageHook checks the images used while the job runs against the image policy, like the ones of docker actions.
+// act has no hook to pull images or create containers, so it checks the messages act logs right before doing so,
+// and cancels the job if the image is rejected. The images built from Dockerfiles are rejected, since their base images can't be checked.
+// The check happens only once act is about to pull the image, not before the job starts like checkImages,
+// so the pull is only stopped by the cancelled context of the job, and a rejected image may be on the host already.
+// It's tied to the format of the messages of act v0.261.3, which Test_imageHook_act checks with the executors of act,
+// since the policy would silently stop applying to these images if the messages changed.
+type imageHook struct {
+	log.Hook
+	policy  *imagepolicy.Policy
+	trusted string // trusted is the image of the label, it's chosen by the runner
+	cancel  context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// newImageHook returns a hook passing the log entries to next, or nil if policy doesn't restrict any image.
+// cancel is called once an image is rejected.
+func newImageHook(next log.Hook, policy config.ImagePolicy, trusted string, cancel context.CancelFunc) (*imageHook, error) {
+	p, err := imagepolicy.New(policy.Allow, policy.Deny, policy.RequireDigest)
+	if err != nil || !p.Enabled() {
+		return nil, err
+	}
+	return &imageHook{Hook: next, policy: p, trusted: trusted, cancel: cancel}, nil
+}
+
+func (h *imageHook) Fire(entry *log.Entry) error {
+	if raw, _ := entry.Data["raw_output"].(bool); !raw {
+		if err := h.check(entry.Message); err != nil {
+			h.mu.Lock()
+			if h.err == nil {
+				h.err = fmt.Errorf("the image policy of the runner rejects the job: %w", err)
+			}
+			h.mu.Unlock()
+			h.cancel()
+		}
+	}
+	return h.Hook.Fire(entry)
+}
+
+func (h *imageHook) check(message string) error {
+	if m := dockerBuildPattern.FindStringSubmatch(message); m != nil {
+		return fmt.Errorf("image %q of a docker action is built from a Dockerfile, its base image can't be checked", m[1])
+	}
+	m := dockerImagePattern.FindStringSubmatch(message)
+	if m == nil || m[1] == h.trusted {
+		return nil
+	}
+	if builtImagePattern.MatchString(m[1]) {
+		return fmt.Errorf("image %q of a docker action is built from a Dockerfile, its base image can't be checked", m[1])
+	}
+	return h.policy.Check(m[1])
+}
+
+// Err returns the error of the first image rejected.
+func (h *imageHook) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// newJobInterpreter returns an interpreter for the expressions in job which are known before it starts.
+// It supports the contexts available to the server, i.e. github, needs, strategy, matrix and vars.
+func newJobInterpreter(task *runnerv1.Task, jobID string, job *model.Job) exprparser.Interpreter {
+	var matrix map[string]any
+	// the job of a task has at most one combination of the matrix
+	if matrixes, err := job.GetMatrixes(); err == nil && len(matrixes) == 1 {
+		matrix = matrixes[0]
+	}
+	results := map[string]*jobparser.JobResult{
+		jobID: {Needs: job.Needs()},
+	}
+	for id, need := range task.Needs {
+		results[id] = &jobparser.JobResult{
+			Result:  strings.ToLower(strings.TrimPrefix(need.Result.String(), "RESULT_")),
+			Outputs: need.Outputs,
+		}
+	}
+	return jobparser.NewInterpeter(jobID, job, matrix, newGithubContext(task), results, task.Vars)
+}
+
+// interpolate evaluates the expressions in s.
+// An expression which evaluates to nothing is an error, since it depends on the contexts only known at runtime.
+func interpolate(interpreter exprparser.Interpreter, s string) (string, error) {
+	var err error
+	resolved := expressionPattern.ReplaceAllStringFunc(s, func(expr string) string {
+		value, evalErr := interpreter.Evaluate(strings.TrimSpace(expressionPattern.FindStringSubmatch(expr)[1]), exprparser.DefaultStatusCheckNone)
+		var v string
+		switch value := value.(type) {
+		case string:
+			v = value
+		case int:
+			v = strconv.Itoa(value)
+		case float64:
+			v = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			v = strconv.FormatBool(value)
+		}
+		if (evalErr != nil || v == "") && err == nil {
+			err = fmt.Errorf("%s can't be evaluated", expr)
+		}
+		return v
+	})
+	return resolved, err
+}
+
+// interpolateImage evaluates the expressions in image.
+func interpolateImage(interpreter exprparser.Interpreter, image string) (string, error) {
+	resolved, err := interpolate(interpreter, image)
+	if err != nil {
+		return "", fmt.Errorf("image %q can't be checked before the job starts, %w", image, err)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/nektos/act/pkg/common"
+	"github.com/nektos/act/pkg/container"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+func Test_checkImages(t *testing.T) {
+	taskContext, err := structpb.NewStruct(map[string]any{"repository": "gitea/act_runner"})
+	require.NoError(t, err)
+	task := &runnerv1.Task{
+		Context: taskContext,
+		Vars:    map[string]string{"REGISTRY": "ghcr.io/gitea"},
+		WorkflowPayload: []byte(`
+on: push
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    strategy:
+      matrix:
+        node: [18]
+    container:
+      image: node:${{ matrix.node }}
+    services:
+      cache:
+        image: ${{ vars.REGISTRY }}/redis:7
+      db:
+        image: ${{ env.DB_IMAGE }}
+    steps:
+      - uses: docker://alpine:edge
+      - uses: actions/checkout@v4
+`),
+	}
+	workflow, jobID, err := generateWorkflow(task)
+	require.NoError(t, err)
+	job := workflow.GetJob(jobID)
+
+	assert.NoError(t, checkImages(task, jobID, job, config.ImagePolicy{}))
+
+	err = checkImages(task, jobID, job, config.ImagePolicy{
+		Allow: []string{"docker.io/library/*", "ghcr.io/gitea/**"},
+		Deny:  []string{"alpine:edge"},
+	})
+	assert.EqualError(t, err, "the image policy of the runner rejects the job: "+
+		`image "${{ env.DB_IMAGE }}" can't be checked before the job starts, ${{ env.DB_IMAGE }} can't be evaluated; `+
+		`image "alpine:edge" is denied`)
+
+	err = checkImages(task, jobID, job, config.ImagePolicy{Allow: []string{"node:16"}})
+	assert.ErrorContains(t, err, `image "node:18" isn't allowed`)
+	assert.ErrorContains(t, err, `image "ghcr.io/gitea/redis:7" isn't allowed`)
+
+	err = checkImages(task, jobID, job, config.ImagePolicy{Allow: []string{"regex:("}})
+	assert.ErrorContains(t, err, `invalid pattern "regex:("`)
+}
+
+type recordHook struct {
+	entries []*log.Entry
+}
+
+func (h *recordHook) Levels() []log.Level { return log.AllLevels }
+
+func (h *recordHook) Fire(entry *log.Entry) error {
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+func Test_imageHook(t *testing.T) {
+	hook, err := newImageHook(&recordHook{}, config.ImagePolicy{}, "", func() {})
+	require.NoError(t, err)
+	assert.Nil(t, hook, "no hook is needed without a policy")
+
+	policy := config.ImagePolicy{Allow: []string{"node:*", "gitea/runner-images:*"}}
+	tests := []struct {
+		name    string
+		message string
+		raw     bool
+		wantErr string
+	}{
+		{name: "allowed", message: "  🐳  docker pull image=node:18 platform= username= forcePull=true"},
+		{name: "label image", message: "  🐳  docker create image=catthehacker/ubuntu:act-latest platform= entrypoint=[] cmd=[] network=\"\""},
+		{name: "other message", message: "  ☁  git clone 'https://gitea.com/actions/checkout' # ref=v4"},
+		{name: "step output", message: "docker pull image=alpine:edge platform=", raw: true},
+		{
+			name:    "docker action image",
+			message: "  🐳  docker pull image=alpine:edge platform= username= forcePull=true",
+			wantErr: `the image policy of the runner rejects the job: image "alpine:edge" isn't allowed`,
+		},
+		{
+			name:    "docker action built from a Dockerfile",
+			message: "  🐳  docker build -t act-owner-action-dockeraction:latest /actions/owner-action@v1/",
+			wantErr: `the image policy of the runner rejects the job: image "act-owner-action-dockeraction:latest" of a docker action is built from a Dockerfile, its base image can't be checked`,
+		},
+		{
+			name:    "docker action built by a previous job",
+			message: "  🐳  docker create image=act-owner-action-dockeraction:latest platform= entrypoint=[] cmd=[] network=\"\"",
+			wantErr: `the image policy of the runner rejects the job: image "act-owner-action-dockeraction:latest" of a docker action is built from a Dockerfile, its base image can't be checked`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recordHook{}
+			cancelled := false
+			hook, err := newImageHook(next, policy, "catthehacker/ubuntu:act-latest", func() { cancelled = true })
+			require.NoError(t, err)
+
+			entry := &log.Entry{Message: tt.message, Data: log.Fields{}}
+			if tt.raw {
+				entry.Data["raw_output"] = true
+			}
+			require.NoError(t, hook.Fire(entry))
+			assert.Len(t, next.entries, 1, "the entries are passed on")
+			if tt.wantErr == "" {
+				assert.NoError(t, hook.Err())
+				assert.False(t, cancelled)
+				return
+			}
+			assert.EqualError(t, hook.Err(), tt.wantErr)
+			assert.True(t, cancelled, "the job is cancelled before the image is used")
+		})
+	}
+}
+
+// Test_imageHook_act checks the hook against the messages logged by the executors of act,
+// so a change of their format in a new version of act fails here instead of disabling the policy.
+func Test_imageHook_act(t *testing.T) {
+	policy := config.ImagePolicy{Allow: []string{"node:*"}}
+	tests := []struct {
+		name     string
+		executor common.Executor
+		wantErr  string
+	}{
+		{
+			name:     "pull allowed",
+			executor: container.NewContainer(&container.NewContainerInput{Image: "node:18"}).Pull(false),
+		},
+		{
+			name:     "pull",
+			executor: container.NewContainer(&container.NewContainerInput{Image: "alpine:edge"}).Pull(false),
+			wantErr:  `the image policy of the runner rejects the job: image "alpine:edge" isn't allowed`,
+		},
+		{
+			name:     "create",
+			executor: container.NewContainer(&container.NewContainerInput{Image: "alpine:edge", Platform: "linux/amd64"}).Create(nil, nil),
+			wantErr:  `the image policy of the runner rejects the job: image "alpine:edge" isn't allowed`,
+		},
+		{
+			name:     "build",
+			executor: container.NewDockerBuildExecutor(container.NewDockerBuildExecutorInput{ContextDir: "/actions/owner-action@v1/", ImageTag: "act-owner-action-dockeraction:latest"}),
+			wantErr:  `the image policy of the runner rejects the job: image "act-owner-action-dockeraction:latest" of a docker action is built from a Dockerfile, its base image can't be checked`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook, err := newImageHook(&recordHook{}, policy, "", func() {})
+			require.NoError(t, err)
+			logger := log.New()
+			logger.SetOutput(io.Discard)
+			logger.SetLevel(log.TraceLevel)
+			logger.AddHook(hook)
+			// the executors only log in dry run, without calling docker
+			ctx := common.WithDryrun(common.WithLogger(context.Background(), logger), true)
+
+			require.NoError(t, tt.executor(ctx))
+			if tt.wantErr == "" {
+				assert.NoError(t, hook.Err())
+				return
+			}
+			assert.EqualError(t, hook.Err(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/nektos/act/pkg/exprparser"
+	"github.com/nektos/act/pkg/model"
+	"gopkg.in/yaml.v3"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+// limitFlags are the options of docker run which can change each limit, without the leading dashes.
+var limitFlags = struct {
+	cpus, memory, pids, disk []string
+}{
+	cpus:   []string{"cpus", "cpu-period", "cpu-quota"},
+	memory: []string{"m", "memory", "memory-swap", "kernel-memory"},
+	pids:   []string{"pids-limit"},
+	disk:   []string{"storage-opt"},
+}
+
+// limitOptions returns the options of docker run which apply limits.
+func limitOptions(limits config.ContainerLimits) string {
+	var opts []string
+	if limits.CPUs > 0 {
+		opts = append(opts, "--cpus", strconv.FormatFloat(limits.CPUs, 'f', -1, 64))
+	}
+	if limits.Memory != "" {
+		// the swap is limited to the memory too
+		opts = append(opts, "--memory", limits.Memory, "--memory-swap", limits.Memory)
+	}
+	if limits.Pids > 0 {
+		opts = append(opts, "--pids-limit", strconv.FormatInt(limits.Pids, 10))
+	}
+	for _, ulimit := range limits.Ulimits {
+		opts = append(opts, "--ulimit", ulimit)
+	}
+	for _, tmpfs := range limits.Tmpfs {
+		opts = append(opts, "--tmpfs", tmpfs)
+	}
+	if limits.DiskSize != "" {
+		opts = append(opts, "--storage-opt", "size="+limits.DiskSize)
+	}
+	return joinOptions(opts)
+}
+
+// stripLimitOptions removes the options which would override limits from the options of a workflow.
+// It returns the options unchanged if there's nothing to remove.
+func stripLimitOptions(options string, limits config.ContainerLimits) (string, []string, error) {
+	args, err := shellquote.Split(options)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot split container options %q: %w", options, err)
+	}
+
+	var names []string
+	if limits.CPUs > 0 {
+		names = append(names, limitFlags.cpus...)
+	}
+	if limits.Memory != "" {
+		names = append(names, limitFlags.memory...)
+	}
+	if limits.Pids > 0 {
+		names = append(names, limitFlags.pids...)
+	}
+	if limits.DiskSize != "" {
+		names = append(names, limitFlags.disk...)
+	}
+	ulimits := make([]string, 0, len(limits.Ulimits))
+	for _, ulimit := range limits.Ulimits {
+		name, _, _ := strings.Cut(ulimit, "=")
+		ulimits = append(ulimits, name)
+	}
+	tmpfs := make([]string, 0, len(limits.Tmpfs))
+	for _, t := range limits.Tmpfs {
+		path, _, _ := strings.Cut(t, ":")
+		tmpfs = append(tmpfs, path)
+	}
+	limited := func(name, value string) bool {
+		switch name {
+		case "ulimit":
+			n, _, _ := strings.Cut(value, "=")
+			return slices.Contains(ulimits, n)
+		case "tmpfs":
+			p, _, _ := strings.Cut(value, ":")
+			return slices.Contains(tmpfs, p)
+		}
+		return slices.Contains(names, name)
+	}
+
+	var kept, stripped []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := "", "", false
+		switch {
+		case strings.HasPrefix(arg, "--") && len(arg) > 2:
+			name, value, hasValue = strings.Cut(arg[2:], "=")
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// short options can be combined like "-itm4g", the first one taking a value takes the rest
+			short := strings.TrimLeft(arg[1:], "diPt")
+			if short == "" {
+				break
+			}
+			name = short[:1]
+			value = strings.TrimPrefix(short[1:], "=")
+			hasValue = len(short) > 1
+		}
+		if name == "" || !isValueFlag(name) {
+			kept = append(kept, arg)
+			continue
+		}
+		option := arg
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+			option += " " + value
+		}
+		if limited(name, value) {
+			stripped = append(stripped, option)
+			continue
+		}
+		kept = append(kept, arg)
+		if !hasValue && value != "" {
+			kept = append(kept, value)
+		}
+	}
+	if len(stripped) == 0 {
+		return options, nil, nil
+	}
+	return joinOptions(kept), stripped, nil
+}
+
+// isValueFlag reports whether the option of docker run with name takes a value, it's only for the options checked by limits.
+func isValueFlag(name string) bool {
+	return name == "ulimit" || name == "tmpfs" ||
+		slices.Contains(limitFlags.cpus, name) ||
+		slices.Contains(limitFlags.memory, name) ||
+		slices.Contains(limitFlags.pids, name) ||
+		slices.Contains(limitFlags.disk, name)
+}
+
+// joinOptions joins options, quoting only the ones with spaces or quotes so the expressions in them still work.
+func joinOptions(opts []string) string {
+	quoted := make([]string, 0, len(opts))
+	for _, opt := range opts {
+		if opt == "" || strings.ContainsAny(opt, " \t\n'\"\\") {
+			opt = "'" + strings.ReplaceAll(opt, "'", `'\''`) + "'"
+		}
+		quoted = append(quoted, opt)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// applyLimits removes the options overriding limits from the job container and the service containers of job,
+// and adds limits to the service containers, which don't get the options of the runner.
+// The expressions in the options are evaluated by interpreter first, since they could evaluate to options overriding limits.
+// It returns the removed options.
+func applyLimits(job *model.Job, limits config.ContainerLimits, interpreter exprparser.Interpreter) ([]string, error) {
+	opts := limitOptions(limits)
+	if opts == "" {
+		return nil, nil
+	}
+
+	var stripped []string
+	if job.RawContainer.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(job.RawContainer.Content); i += 2 {
+			if job.RawContainer.Content[i].Value != "options" {
+				continue
+			}
+			node := job.RawContainer.Content[i+1]
+			options, err := interpolateOptions(interpreter, node.Value)
+			if err != nil {
+				return nil, err
+			}
+			options, s, err := stripLimitOptions(options, limits)
+			if err != nil {
+				return nil, err
+			}
+			node.Value = options
+			stripped = append(stripped, s...)
+		}
+	}
+
+	names := make([]string, 0, len(job.Services))
+	for name := range job.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		service := job.Services[name]
+		if service == nil {
+			continue
+		}
+		options, err := interpolateOptions(interpreter, service.Options)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		options, s, err := stripLimitOptions(options, limits)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		service.Options = strings.TrimSpace(opts + " " + options)
+		stripped = append(stripped, s...)
+	}
+	return stripped, nil
+}
+
+// interpolateOptions evaluates the expressions in options.
+// Options depending on the contexts only known at runtime are rejected, since they can't be checked against the limits.
+func interpolateOptions(interpreter exprparser.Interpreter, options string) (string, error) {
+	if !strings.Contains(options, "${{") {
+		return options, nil
+	}
+	resolved, err := interpolate(interpreter, options)
+	if err != nil {
+		return "", fmt.Errorf("container options %q can't be checked against the limits of the runner, %w", options, err)
+	}
+	return resolved, nil
+}
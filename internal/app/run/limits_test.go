@@ -0,0 +1,142 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"testing"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+var testLimits = config.ContainerLimits{
+	CPUs:     2.5,
+	Memory:   "4g",
+	Pids:     1024,
+	Ulimits:  []string{"nofile=1024:2048"},
+	Tmpfs:    []string{"/tmp:size=1g"},
+	DiskSize: "20g",
+}
+
+func Test_limitOptions(t *testing.T) {
+	assert.Equal(t, "", limitOptions(config.ContainerLimits{}))
+	assert.Equal(t,
+		"--cpus 2.5 --memory 4g --memory-swap 4g --pids-limit 1024 --ulimit nofile=1024:2048 --tmpfs /tmp:size=1g --storage-opt size=20g",
+		limitOptions(testLimits))
+}
+
+func Test_stripLimitOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  string
+		limits   config.ContainerLimits
+		want     string
+		stripped []string
+	}{
+		{
+			name:    "nothing to strip",
+			options: "--cpus 8 --env FOO=${{ matrix.foo }}",
+			limits:  config.ContainerLimits{Memory: "4g"},
+			want:    "--cpus 8 --env FOO=${{ matrix.foo }}",
+		},
+		{
+			name:     "long options",
+			options:  "--cpus=8 --memory 16g --hostname build --pids-limit -1",
+			limits:   testLimits,
+			want:     "--hostname build",
+			stripped: []string{"--cpus=8", "--memory 16g", "--pids-limit -1"},
+		},
+		{
+			name:     "combined short options",
+			options:  "-itm16g -e 'A=a b' -tm 8g",
+			limits:   testLimits,
+			want:     "-e 'A=a b'",
+			stripped: []string{"-itm16g", "-tm 8g"},
+		},
+		{
+			name:     "ulimits and tmpfs by name",
+			options:  "--ulimit nofile=65536 --ulimit core=0 --tmpfs /tmp:size=64g --tmpfs /run",
+			limits:   testLimits,
+			want:     "--ulimit core=0 --tmpfs /run",
+			stripped: []string{"--ulimit nofile=65536", "--tmpfs /tmp:size=64g"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stripped, err := stripLimitOptions(tt.options, tt.limits)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.stripped, stripped)
+		})
+	}
+}
+
+func Test_applyLimits(t *testing.T) {
+	taskContext, err := structpb.NewStruct(map[string]any{"repository": "gitea/act_runner"})
+	require.NoError(t, err)
+	task := &runnerv1.Task{
+		Context: taskContext,
+		Vars:    map[string]string{"DB_MEMORY": "32g"},
+		WorkflowPayload: []byte(`
+on: push
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    container:
+      image: node:18
+      options: ${{ '--memory 64g' }} --cpus 16
+    services:
+      db:
+        image: postgres:16
+        options: --health-cmd pg_isready --memory=${{ vars.DB_MEMORY }}
+    steps:
+      - run: make test
+`),
+	}
+	workflow, jobID, err := generateWorkflow(task)
+	require.NoError(t, err)
+	job := workflow.GetJob(jobID)
+
+	stripped, err := applyLimits(job, config.ContainerLimits{CPUs: 2, Memory: "4g"}, newJobInterpreter(task, jobID, job))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--memory 64g", "--cpus 16", "--memory=32g"}, stripped)
+	assert.Equal(t, "", job.Container().Options)
+	assert.Equal(t, "--cpus 2 --memory 4g --memory-swap 4g --health-cmd pg_isready", job.Services["db"].Options)
+}
+
+func Test_applyLimits_UnknownOptions(t *testing.T) {
+	taskContext, err := structpb.NewStruct(map[string]any{})
+	require.NoError(t, err)
+	task := &runnerv1.Task{
+		Context: taskContext,
+		WorkflowPayload: []byte(`
+on: push
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    container:
+      image: node:18
+      options: ${{ env.OPTIONS }}
+    steps:
+      - run: make test
+`),
+	}
+	workflow, jobID, err := generateWorkflow(task)
+	require.NoError(t, err)
+	job := workflow.GetJob(jobID)
+	interpreter := newJobInterpreter(task, jobID, job)
+
+	_, err = applyLimits(job, config.ContainerLimits{Memory: "4g"}, interpreter)
+	assert.EqualError(t, err, `container options "${{ env.OPTIONS }}" can't be checked against the limits of the runner, ${{ env.OPTIONS }} can't be evaluated`)
+
+	// without limits, the options are left to act
+	stripped, err := applyLimits(job, config.ContainerLimits{}, interpreter)
+	require.NoError(t, err)
+	assert.Empty(t, stripped)
+	assert.Equal(t, "${{ env.OPTIONS }}", job.Container().Options)
+}
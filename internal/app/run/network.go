@@ -0,0 +1,58 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+
+	"gitea.com/gitea/act_runner/internal/pkg/reaper"
+)
+
+// network is the network of a task created by the runner instead of act,
+// since act can't label its networks and the reaper only removes the networks labeled with the owner.
+type network struct {
+	Name string
+
+	cli *client.Client
+	id  string
+}
+
+// createNetwork creates the bridge network of task on the docker daemon of DOCKER_HOST, labeled with owner.
+func createNetwork(ctx context.Context, task *runnerv1.Task, owner string) (*network, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s%d-network", reaper.NamePrefix, task.Id)
+	resp, err := cli.NetworkCreate(ctx, name, types.NetworkCreate{Driver: "bridge", Labels: ownerLabels(owner)})
+	if err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("cannot create network %s: %w", name, err)
+	}
+	return &network{Name: name, cli: cli, id: resp.ID}, nil
+}
+
+// Remove removes the network, and closes the client.
+func (n *network) Remove(ctx context.Context) error {
+	var errs []error
+	if err := n.cli.NetworkRemove(ctx, n.id); err != nil {
+		errs = append(errs, fmt.Errorf("cannot remove network %s: %w", n.Name, err))
+	}
+	errs = append(errs, n.cli.Close())
+	return errors.Join(errs...)
+}
+
+// ownerLabels returns the labels of the resources created by the runner with uuid owner.
+func ownerLabels(owner string) map[string]string {
+	if owner == "" {
+		return nil
+	}
+	return map[string]string{reaper.OwnerLabel: owner}
+}
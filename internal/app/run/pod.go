@@ -0,0 +1,116 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/docker/go-connections/nat"
+	"github.com/nektos/act/pkg/exprparser"
+	"github.com/nektos/act/pkg/model"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/podman"
+	"gitea.com/gitea/act_runner/internal/pkg/reaper"
+)
+
+// pod is the pod of a job of a podman label.
+type pod struct {
+	cli     *podman.Client
+	name    string
+	infraID string // infraID is the ID of the infra container owning the namespaces of the pod
+}
+
+// createPod creates the pod of task with the libpod API for a job of a podman label.
+// The job container and the service containers join the network namespace of the pod, so the services are reachable
+// on localhost and by their names, and the ports of the services are published by the pod instead.
+func createPod(ctx context.Context, task *runnerv1.Task, job *model.Job, containerCfg config.Container, interpreter exprparser.Interpreter, owner string) (*pod, error) {
+	spec := podman.PodSpec{
+		Name: fmt.Sprintf("%s%d-pod", reaper.NamePrefix, task.Id),
+	}
+	if owner != "" {
+		spec.Labels = map[string]string{reaper.OwnerLabel: owner}
+	}
+	if containerCfg.PodmanUserns != "" {
+		mode, value, _ := strings.Cut(containerCfg.PodmanUserns, ":")
+		spec.Userns = &podman.Namespace{Mode: mode, Value: value}
+	}
+
+	names := make([]string, 0, len(job.Services))
+	for name := range job.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		service := job.Services[name]
+		if service == nil {
+			continue
+		}
+		spec.HostAdd = append(spec.HostAdd, name+":127.0.0.1")
+		mappings, err := podPortMappings(interpreter, service.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		spec.PortMappings = append(spec.PortMappings, mappings...)
+		// the containers sharing the network namespace of the pod can't publish ports
+		service.Ports = nil
+	}
+
+	cli, err := podman.NewClient(containerCfg.PodmanHost)
+	if err != nil {
+		return nil, err
+	}
+	infraID, err := cli.CreatePod(ctx, spec)
+	if err != nil {
+		cli.Close()
+		return nil, err
+	}
+	return &pod{cli: cli, name: spec.Name, infraID: infraID}, nil
+}
+
+// podPortMappings returns the port mappings of the pod for ports of a service, like "5432" or "127.0.0.1:8080:80/udp".
+func podPortMappings(interpreter exprparser.Interpreter, ports []string) ([]podman.PortMapping, error) {
+	var mappings []podman.PortMapping
+	for _, port := range ports {
+		resolved, err := interpolate(interpreter, port)
+		if err != nil {
+			return nil, fmt.Errorf("port %q can't be published by the pod, %w", port, err)
+		}
+		parsed, err := nat.ParsePortSpec(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", port, err)
+		}
+		for _, p := range parsed {
+			var hostPort uint64
+			if p.Binding.HostPort != "" {
+				if hostPort, err = strconv.ParseUint(p.Binding.HostPort, 10, 16); err != nil {
+					return nil, fmt.Errorf("invalid port %q: %w", port, err)
+				}
+			}
+			mappings = append(mappings, podman.PortMapping{
+				HostIP:        p.Binding.HostIP,
+				ContainerPort: uint16(p.Port.Int()),
+				HostPort:      uint16(hostPort),
+				Protocol:      p.Port.Proto(),
+			})
+		}
+	}
+	return mappings, nil
+}
+
+// NetworkMode returns the network mode of the containers joining the network namespace of the pod.
+func (p *pod) NetworkMode() string {
+	return "container:" + p.infraID
+}
+
+// Remove removes the pod and closes the client.
+func (p *pod) Remove(ctx context.Context) error {
+	defer p.cli.Close()
+	return p.cli.RemovePod(ctx, p.name)
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/podman"
+	"gitea.com/gitea/act_runner/internal/pkg/reaper"
+)
+
+func Test_createPod(t *testing.T) {
+	var spec podman.PodSpec
+	var removed string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v4.0.0/libpod/pods/create", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&spec))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"Id":"pod-id"}`))
+	})
+	mux.HandleFunc("GET /v4.0.0/libpod/pods/pod-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"InfraContainerID":"infra-id"}`))
+	})
+	mux.HandleFunc("DELETE /v4.0.0/libpod/pods/{name}", func(_ http.ResponseWriter, r *http.Request) {
+		removed = r.PathValue("name")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	taskContext, err := structpb.NewStruct(map[string]any{"repository": "gitea/act_runner"})
+	require.NoError(t, err)
+	task := &runnerv1.Task{
+		Id:      42,
+		Context: taskContext,
+		Vars:    map[string]string{"REDIS_PORT": "16379"},
+		WorkflowPayload: []byte(`
+on: push
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    services:
+      redis:
+        image: redis:7
+        ports: ["${{ vars.REDIS_PORT }}:6379"]
+      db:
+        image: postgres:16
+        ports: ["5432", "127.0.0.1:8053:53/udp"]
+    steps:
+      - run: echo
+`),
+	}
+	workflow, jobID, err := generateWorkflow(task)
+	require.NoError(t, err)
+	job := workflow.GetJob(jobID)
+
+	p, err := createPod(context.Background(), task, job, config.Container{
+		PodmanHost:   "tcp://" + srv.Listener.Addr().String(),
+		PodmanUserns: "keep-id:uid=1000",
+	}, newJobInterpreter(task, jobID, job), "runner-uuid")
+	require.NoError(t, err)
+	assert.Equal(t, "container:infra-id", p.NetworkMode())
+
+	assert.Equal(t, podman.PodSpec{
+		Name:    "GITEA-ACTIONS-TASK-42-pod",
+		Labels:  map[string]string{reaper.OwnerLabel: "runner-uuid"},
+		HostAdd: []string{"db:127.0.0.1", "redis:127.0.0.1"},
+		PortMappings: []podman.PortMapping{
+			{ContainerPort: 5432, Protocol: "tcp"},
+			{HostIP: "127.0.0.1", ContainerPort: 53, HostPort: 8053, Protocol: "udp"},
+			{ContainerPort: 6379, HostPort: 16379, Protocol: "tcp"},
+		},
+		Userns: &podman.Namespace{Mode: "keep-id", Value: "uid=1000"},
+	}, spec)
+	assert.Empty(t, job.Services["db"].Ports, "the ports are published by the pod")
+	assert.Empty(t, job.Services["redis"].Ports)
+
+	require.NoError(t, p.Remove(context.Background()))
+	assert.Equal(t, "GITEA-ACTIONS-TASK-42-pod", removed)
+}
+
+func Test_createPod_UnknownPort(t *testing.T) {
+	taskContext, err := structpb.NewStruct(map[string]any{})
+	require.NoError(t, err)
+	task := &runnerv1.Task{
+		Context: taskContext,
+		WorkflowPayload: []byte(`
+on: push
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    services:
+      db:
+        image: postgres:16
+        ports: ["${{ env.DB_PORT }}:5432"]
+    steps:
+      - run: echo
+`),
+	}
+	workflow, jobID, err := generateWorkflow(task)
+	require.NoError(t, err)
+	job := workflow.GetJob(jobID)
+
+	_, err = createPod(context.Background(), task, job, config.Container{PodmanHost: "tcp://127.0.0.1:1"}, newJobInterpreter(task, jobID, job), "")
+	assert.EqualError(t, err, `service db: port "${{ env.DB_PORT }}:5432" can't be published by the pod, ${{ env.DB_PORT }} can't be evaluated`)
+}
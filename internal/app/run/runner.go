@@ -6,7 +6,9 @@ package run
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -17,6 +19,7 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/nektos/act/pkg/artifactcache"
 	"github.com/nektos/act/pkg/common"
+	"github.com/nektos/act/pkg/exprparser"
 	"github.com/nektos/act/pkg/model"
 	"github.com/nektos/act/pkg/runner"
 	log "github.com/sirupsen/logrus"
@@ -24,6 +27,8 @@ import (
 	"gitea.com/gitea/act_runner/internal/pkg/client"
 	"gitea.com/gitea/act_runner/internal/pkg/config"
 	"gitea.com/gitea/act_runner/internal/pkg/labels"
+	"gitea.com/gitea/act_runner/internal/pkg/metrics"
+	"gitea.com/gitea/act_runner/internal/pkg/reaper"
 	"gitea.com/gitea/act_runner/internal/pkg/report"
 	"gitea.com/gitea/act_runner/internal/pkg/ver"
 )
@@ -31,30 +36,31 @@ import (
 // Runner runs the pipeline.
 type Runner struct {
 	name string
+	uuid string
 
-	cfg *config.Config
+	client   client.Client
+	cacheURL string
 
-	client client.Client
-	labels labels.Labels
-	envs   map[string]string
+	mu        sync.RWMutex
+	cfg       *config.Config
+	labels    labels.Labels
+	labelSets labels.Sets
+	envs      map[string]string
 
 	runningTasks sync.Map
 }
 
-func NewRunner(cfg *config.Config, reg *config.Registration, cli client.Client) *Runner {
+func NewRunner(cfg *config.Config, reg *config.Registration, cli client.Client, sets labels.Sets) *Runner {
 	ls := labels.Labels{}
 	for _, v := range reg.Labels {
 		if l, err := labels.Parse(v); err == nil {
 			ls = append(ls, l)
 		}
 	}
-	envs := make(map[string]string, len(cfg.Runner.Envs))
-	for k, v := range cfg.Runner.Envs {
-		envs[k] = v
-	}
+	var cacheURL string
 	if cfg.Cache.Enabled == nil || *cfg.Cache.Enabled {
 		if cfg.Cache.ExternalServer != "" {
-			envs["ACTIONS_CACHE_URL"] = cfg.Cache.ExternalServer
+			cacheURL = cfg.Cache.ExternalServer
 		} else {
 			cacheHandler, err := artifactcache.StartHandler(
 				cfg.Cache.Dir,
@@ -66,27 +72,65 @@ func NewRunner(cfg *config.Config, reg *config.Registration, cli client.Client)
 				log.Errorf("cannot init cache server, it will be disabled: %v", err)
 				// go on
 			} else {
-				envs["ACTIONS_CACHE_URL"] = cacheHandler.ExternalURL() + "/"
+				cacheURL = cacheHandler.ExternalURL() + "/"
 			}
 		}
 	}
 
+	r := &Runner{
+		name:     reg.Name,
+		uuid:     reg.UUID,
+		client:   cli,
+		cacheURL: cacheURL,
+	}
+	r.Update(cfg, ls, sets)
+	return r
+}
+
+// Update replaces the configuration and the labels used by tasks started afterwards.
+// The cache server can't be changed once the runner has been created.
+func (r *Runner) Update(cfg *config.Config, ls labels.Labels, sets labels.Sets) {
+	envs := make(map[string]string, len(cfg.Runner.Envs))
+	for k, v := range cfg.Runner.Envs {
+		envs[k] = v
+	}
+	if r.cacheURL != "" {
+		envs["ACTIONS_CACHE_URL"] = r.cacheURL
+	}
+
 	// set artifact gitea api
-	artifactGiteaAPI := strings.TrimSuffix(cli.Address(), "/") + "/api/actions_pipeline/"
+	artifactGiteaAPI := strings.TrimSuffix(r.client.Address(), "/") + "/api/actions_pipeline/"
 	envs["ACTIONS_RUNTIME_URL"] = artifactGiteaAPI
-	envs["ACTIONS_RESULTS_URL"] = strings.TrimSuffix(cli.Address(), "/")
+	envs["ACTIONS_RESULTS_URL"] = strings.TrimSuffix(r.client.Address(), "/")
 
 	// Set specific environments to distinguish between Gitea and GitHub
 	envs["GITEA_ACTIONS"] = "true"
 	envs["GITEA_ACTIONS_RUNNER_VERSION"] = ver.Version()
 
-	return &Runner{
-		name:   reg.Name,
-		cfg:    cfg,
-		client: cli,
-		labels: ls,
-		envs:   envs,
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cfg = cfg
+	r.labels = ls
+	r.labelSets = sets
+	r.envs = envs
+}
+
+// Running reports whether task id is running on the runner.
+func (r *Runner) Running(id int64) bool {
+	_, ok := r.runningTasks.Load(id)
+	return ok
+}
+
+// snapshot returns the configuration, the labels and a copy of the envs for a task.
+func (r *Runner) snapshot() (*config.Config, labels.Labels, labels.Sets, map[string]string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	envs := make(map[string]string, len(r.envs)+1)
+	for k, v := range r.envs {
+		envs[k] = v
 	}
+	return r.cfg, r.labels, r.labelSets, envs
 }
 
 func (r *Runner) Run(ctx context.Context, task *runnerv1.Task) error {
@@ -95,10 +139,26 @@ func (r *Runner) Run(ctx context.Context, task *runnerv1.Task) error {
 	}
 	r.runningTasks.Store(task.Id, struct{}{})
 	defer r.runningTasks.Delete(task.Id)
+	metrics.RunningTasks.Inc()
+	defer metrics.RunningTasks.Dec()
+
+	cfg, ls, sets, envs := r.snapshot()
+
+	// the workspace is removed after the reporter has been closed
+	defer func() {
+		if err := os.RemoveAll(r.taskWorkdir(cfg, task.Id)); err != nil {
+			log.Warnf("cannot remove the workspace of task %d: %v", task.Id, err)
+		}
+	}()
 
-	ctx, cancel := context.WithTimeout(ctx, r.cfg.Runner.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, cfg.Runner.Timeout)
 	defer cancel()
 	reporter := report.NewReporter(ctx, cancel, r.client, task)
+	if spool, err := report.OpenSpool(cfg.Runner.SpoolDir, task.Id); err != nil {
+		log.Warnf("cannot spool the logs and the state of task %d: %v", task.Id, err)
+	} else {
+		reporter.SetSpool(spool)
+	}
 	var runErr error
 	defer func() {
 		lastWords := ""
@@ -108,12 +168,12 @@ func (r *Runner) Run(ctx context.Context, task *runnerv1.Task) error {
 		_ = reporter.Close(lastWords)
 	}()
 	reporter.RunDaemon()
-	runErr = r.run(ctx, task, reporter)
+	runErr = r.run(ctx, task, reporter, cfg, ls, sets, envs)
 
 	return nil
 }
 
-func (r *Runner) run(ctx context.Context, task *runnerv1.Task, reporter *report.Reporter) (err error) {
+func (r *Runner) run(ctx context.Context, task *runnerv1.Task, reporter *report.Reporter, cfg *config.Config, ls labels.Labels, sets labels.Sets, envs map[string]string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("panic: %v", r)
@@ -134,47 +194,131 @@ func (r *Runner) run(ctx context.Context, task *runnerv1.Task, reporter *report.
 	job := workflow.GetJob(jobID)
 	reporter.ResetSteps(len(job.Steps))
 
+	runsOn := evaluateRunsOn(newJobInterpreter(task, jobID, job), job.RunsOn())
+	label, err := ls.Pick(runsOn, sets...)
+	if errors.Is(err, labels.ErrNoMatch) {
+		label, err = pickNoMatch(cfg, ls, err, reporter)
+	}
+	if err != nil {
+		return err
+	}
+	reporter.Logf("runs-on %v matched label %s", runsOn, labels.Labels{label}.ToStrings()[0])
+
+	if err := checkImages(task, jobID, job, cfg.Container.ForLabel(label.Name).Images); err != nil {
+		return err
+	}
+
+	if label.Schema == labels.SchemeHost {
+		// each task has its own workspace, so concurrent tasks of the same repository don't collide
+		hostCfg := *cfg
+		hostCfg.Host.WorkdirParent = r.taskWorkdir(cfg, task.Id)
+		cfg = &hostCfg
+	}
+
+	if label.Sandboxed() {
+		return r.runSandbox(ctx, task, job, reporter, cfg, envs, label)
+	}
+	return execute(ctx, task, plan, jobID, job, reporter, cfg, envs, label, r.client.Address(), r.uuid)
+}
+
+// jobReporter receives the logs and the results of a job, it's implemented by report.Reporter.
+type jobReporter interface {
+	log.Hook
+	Logf(format string, a ...interface{})
+	SetOutputs(outputs map[string]string)
+	AddSummary(step int, summary string)
+}
+
+// execute runs the job of plan with act on this host, address is the address of the Gitea instance.
+// The containers are labeled with owner, the uuid of the runner, so the reaper can tell its containers.
+func execute(ctx context.Context, task *runnerv1.Task, plan *model.Plan, jobID string, job *model.Job, reporter jobReporter, cfg *config.Config, envs map[string]string, label *labels.Label, address, owner string) error {
 	taskContext := task.Context.Fields
 
 	log.Infof("task %v repo is %v %v %v", task.Id, taskContext["repository"].GetStringValue(),
 		taskContext["gitea_default_actions_url"].GetStringValue(),
-		r.client.Address())
+		address)
 
-	preset := &model.GithubContext{
-		Event:           taskContext["event"].GetStructValue().AsMap(),
-		RunID:           taskContext["run_id"].GetStringValue(),
-		RunNumber:       taskContext["run_number"].GetStringValue(),
-		Actor:           taskContext["actor"].GetStringValue(),
-		Repository:      taskContext["repository"].GetStringValue(),
-		EventName:       taskContext["event_name"].GetStringValue(),
-		Sha:             taskContext["sha"].GetStringValue(),
-		Ref:             taskContext["ref"].GetStringValue(),
-		RefName:         taskContext["ref_name"].GetStringValue(),
-		RefType:         taskContext["ref_type"].GetStringValue(),
-		HeadRef:         taskContext["head_ref"].GetStringValue(),
-		BaseRef:         taskContext["base_ref"].GetStringValue(),
-		Token:           taskContext["token"].GetStringValue(),
-		RepositoryOwner: taskContext["repository_owner"].GetStringValue(),
-		RetentionDays:   taskContext["retention_days"].GetStringValue(),
-	}
-	if t := task.Secrets["GITEA_TOKEN"]; t != "" {
-		preset.Token = t
-	} else if t := task.Secrets["GITHUB_TOKEN"]; t != "" {
-		preset.Token = t
-	}
+	preset := newGithubContext(task)
 
 	giteaRuntimeToken := taskContext["gitea_runtime_token"].GetStringValue()
 	if giteaRuntimeToken == "" {
 		// use task token to action api token for previous Gitea Server Versions
 		giteaRuntimeToken = preset.Token
 	}
-	r.envs["ACTIONS_RUNTIME_TOKEN"] = giteaRuntimeToken
+	envs["ACTIONS_RUNTIME_TOKEN"] = giteaRuntimeToken
 
 	eventJSON, err := json.Marshal(preset.Event)
 	if err != nil {
 		return err
 	}
 
+	containerCfg := cfg.Container.ForLabel(label.Name)
+	containerOptions := containerCfg.Options
+	if label.Schema != labels.SchemeHost {
+		stripped, err := applyLimits(job, containerCfg.Limits, newJobInterpreter(task, jobID, job))
+		if err != nil {
+			return err
+		}
+		if len(stripped) > 0 {
+			reporter.Logf("options %q of the workflow are ignored, they would override the resource limits of the runner", stripped)
+		}
+		containerOptions = strings.TrimSpace(containerOptions + " " + limitOptions(containerCfg.Limits))
+		if owner != "" {
+			ownerOption := "--label " + reaper.OwnerLabel + "=" + owner
+			containerOptions = strings.TrimSpace(containerOptions + " " + ownerOption)
+			for _, service := range job.Services {
+				if service != nil {
+					service.Options = strings.TrimSpace(ownerOption + " " + service.Options)
+				}
+			}
+		}
+	}
+	if containerCfg.Egress.Enabled {
+		network, err := createEgressNetwork(ctx, task, containerCfg, label, envs, address, owner)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+			defer cancel()
+			if err := network.Remove(ctx); err != nil {
+				log.Errorf("failed to remove the egress network of task %d: %v", task.Id, err)
+			}
+		}()
+		containerCfg.Network = network.Name
+		// the docker daemon could start containers outside the network for the job
+		containerCfg.DockerHost = "-"
+		reporter.Logf("the containers of the job can only connect to %s", strings.Join(append([]string{"the Gitea instance", "the cache server"}, containerCfg.Egress.Allow...), ", "))
+	}
+	if label.Schema == labels.SchemePodman && containerCfg.Network == "" {
+		pod, err := createPod(ctx, task, job, containerCfg, newJobInterpreter(task, jobID, job), owner)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+			defer cancel()
+			if err := pod.Remove(ctx); err != nil {
+				log.Errorf("failed to remove the pod of task %d: %v", task.Id, err)
+			}
+		}()
+		containerCfg.Network = pod.NetworkMode()
+	}
+	if label.Schema == labels.SchemeDocker && containerCfg.Network == "" && owner != "" {
+		network, err := createNetwork(ctx, task, owner)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+			defer cancel()
+			if err := network.Remove(ctx); err != nil {
+				log.Errorf("failed to remove the network of task %d: %v", task.Id, err)
+			}
+		}()
+		containerCfg.Network = network.Name
+	}
+
 	maxLifetime := 3 * time.Hour
 	if deadline, ok := ctx.Deadline(); ok {
 		maxLifetime = time.Until(deadline)
@@ -183,33 +327,37 @@ func (r *Runner) run(ctx context.Context, task *runnerv1.Task, reporter *report.
 	runnerConfig := &runner.Config{
 		// On Linux, Workdir will be like "/<parent_directory>/<owner>/<repo>"
 		// On Windows, Workdir will be like "\<parent_directory>\<owner>\<repo>"
-		Workdir:        filepath.FromSlash(fmt.Sprintf("/%s/%s", strings.TrimLeft(r.cfg.Container.WorkdirParent, "/"), preset.Repository)),
+		Workdir:        filepath.FromSlash(fmt.Sprintf("/%s/%s", strings.TrimLeft(containerCfg.WorkdirParent, "/"), preset.Repository)),
 		BindWorkdir:    false,
-		ActionCacheDir: filepath.FromSlash(r.cfg.Host.WorkdirParent),
+		ActionCacheDir: filepath.FromSlash(cfg.Host.WorkdirParent),
 
 		ReuseContainers:       false,
-		ForcePull:             r.cfg.Container.ForcePull,
-		ForceRebuild:          r.cfg.Container.ForceRebuild,
+		ForcePull:             containerCfg.ForcePull,
+		ForceRebuild:          containerCfg.ForceRebuild,
 		LogOutput:             true,
 		JSONLogger:            false,
-		Env:                   r.envs,
+		Env:                   envs,
 		Secrets:               task.Secrets,
-		GitHubInstance:        strings.TrimSuffix(r.client.Address(), "/"),
+		GitHubInstance:        strings.TrimSuffix(address, "/"),
 		AutoRemove:            true,
 		NoSkipCheckout:        true,
 		PresetGitHubContext:   preset,
 		EventJSON:             string(eventJSON),
-		ContainerNamePrefix:   fmt.Sprintf("GITEA-ACTIONS-TASK-%d", task.Id),
+		ContainerNamePrefix:   fmt.Sprintf("%s%d", reaper.NamePrefix, task.Id),
 		ContainerMaxLifetime:  maxLifetime,
-		ContainerNetworkMode:  container.NetworkMode(r.cfg.Container.Network),
-		ContainerOptions:      r.cfg.Container.Options,
-		ContainerDaemonSocket: r.cfg.Container.DockerHost,
-		Privileged:            r.cfg.Container.Privileged,
+		ContainerNetworkMode:  container.NetworkMode(containerCfg.Network),
+		ContainerOptions:      containerOptions,
+		ContainerDaemonSocket: containerCfg.DockerHost,
+		Privileged:            containerCfg.Privileged,
 		DefaultActionInstance: taskContext["gitea_default_actions_url"].GetStringValue(),
-		PlatformPicker:        r.labels.PickPlatform,
+		PlatformPicker:        func(_ []string) string { return label.Platform() }, // the label has been picked from the evaluated runs-on already
 		Vars:                  task.Vars,
-		ValidVolumes:          r.cfg.Container.ValidVolumes,
-		InsecureSkipTLS:       r.cfg.Runner.Insecure,
+		ValidVolumes:          containerCfg.ValidVolumes,
+		InsecureSkipTLS:       cfg.Runner.Insecure,
+	}
+
+	if label.Schema == labels.SchemePodman {
+		runnerConfig.UsernsMode = containerCfg.PodmanUserns
 	}
 
 	rr, err := runner.New(runnerConfig)
@@ -221,7 +369,23 @@ func (r *Runner) run(ctx context.Context, task *runnerv1.Task, reporter *report.
 	reporter.Logf("workflow prepared")
 
 	// add logger recorders
-	ctx = common.WithLoggerHook(ctx, reporter)
+	readSummary, closeSummary := newSummaryReader(task.Id, label, runnerConfig.ActionCacheDir, owner)
+	defer closeSummary()
+	var hook log.Hook = &summaryHook{
+		jobReporter: reporter,
+		ctx:         ctx,
+		read:        readSummary,
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	images, err := newImageHook(hook, containerCfg.Images, label.Platform(), cancel)
+	if err != nil {
+		return err
+	}
+	if images != nil {
+		hook = images
+	}
+	ctx = common.WithLoggerHook(ctx, hook)
 
 	if !log.IsLevelEnabled(log.DebugLevel) {
 		ctx = runner.WithJobLoggerFactory(ctx, NullLogger{})
@@ -229,9 +393,80 @@ func (r *Runner) run(ctx context.Context, task *runnerv1.Task, reporter *report.
 
 	execErr := executor(ctx)
 	reporter.SetOutputs(job.Outputs)
+	if images != nil && images.Err() != nil {
+		return images.Err()
+	}
 	return execErr
 }
 
+// newGithubContext returns the github context of task.
+func newGithubContext(task *runnerv1.Task) *model.GithubContext {
+	taskContext := task.Context.Fields
+	preset := &model.GithubContext{
+		Event:           taskContext["event"].GetStructValue().AsMap(),
+		RunID:           taskContext["run_id"].GetStringValue(),
+		RunNumber:       taskContext["run_number"].GetStringValue(),
+		Actor:           taskContext["actor"].GetStringValue(),
+		Repository:      taskContext["repository"].GetStringValue(),
+		EventName:       taskContext["event_name"].GetStringValue(),
+		Sha:             taskContext["sha"].GetStringValue(),
+		Ref:             taskContext["ref"].GetStringValue(),
+		RefName:         taskContext["ref_name"].GetStringValue(),
+		RefType:         taskContext["ref_type"].GetStringValue(),
+		HeadRef:         taskContext["head_ref"].GetStringValue(),
+		BaseRef:         taskContext["base_ref"].GetStringValue(),
+		Token:           taskContext["token"].GetStringValue(),
+		RepositoryOwner: taskContext["repository_owner"].GetStringValue(),
+		RetentionDays:   taskContext["retention_days"].GetStringValue(),
+	}
+	if t := task.Secrets["GITEA_TOKEN"]; t != "" {
+		preset.Token = t
+	} else if t := task.Secrets["GITHUB_TOKEN"]; t != "" {
+		preset.Token = t
+	}
+	return preset
+}
+
+// legacyNoMatchLabel is the label used by jobs whose labels don't match with config.NoMatchLegacy.
+var legacyNoMatchLabel = &labels.Label{
+	Name:   "ubuntu-latest",
+	Schema: labels.SchemeDocker,
+	Arg:    "//gitea/runner-images:ubuntu-latest",
+}
+
+// evaluateRunsOn evaluates the expressions in the labels of runs-on, like act does before picking the platform.
+// Gitea evaluates them when the workflow is parsed, but the ones depending on contexts it doesn't know are left as they are.
+// A label which can't be evaluated is kept as it is, so it doesn't match any label of the runner.
+func evaluateRunsOn(interpreter exprparser.Interpreter, runsOn []string) []string {
+	evaluated := make([]string, 0, len(runsOn))
+	for _, v := range runsOn {
+		if resolved, err := interpolate(interpreter, v); err == nil {
+			v = resolved
+		}
+		evaluated = append(evaluated, v)
+	}
+	return evaluated
+}
+
+// pickNoMatch returns the label for a job whose labels don't match according to cfg.Runner.NoMatch.
+// The decision is logged to the job log, so users know why the job runs in an unexpected environment.
+func pickNoMatch(cfg *config.Config, ls labels.Labels, noMatch error, reporter *report.Reporter) (*labels.Label, error) {
+	switch cfg.Runner.NoMatch {
+	case config.NoMatchDefault:
+		label := ls.Get(cfg.Runner.DefaultLabel)
+		if label == nil {
+			return nil, fmt.Errorf("%w, and the default label %q isn't a label of the runner", noMatch, cfg.Runner.DefaultLabel)
+		}
+		reporter.Logf("%v, falling back to the default label %q", noMatch, label.Name)
+		return label, nil
+	case config.NoMatchLegacy:
+		reporter.Logf("%v, falling back to the image %s", noMatch, legacyNoMatchLabel.Platform())
+		return legacyNoMatchLabel, nil
+	default:
+		return nil, noMatch
+	}
+}
+
 func (r *Runner) Declare(ctx context.Context, labels []string) (*connect.Response[runnerv1.DeclareResponse], error) {
 	return r.client.Declare(ctx, connect.NewRequest(&runnerv1.DeclareRequest{
 		Version: ver.Version(),
@@ -0,0 +1,41 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"testing"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func Test_evaluateRunsOn(t *testing.T) {
+	taskContext, err := structpb.NewStruct(map[string]any{"repository": "gitea/act_runner"})
+	require.NoError(t, err)
+	task := &runnerv1.Task{
+		Context: taskContext,
+		Vars:    map[string]string{"ARCH": "arm64"},
+		WorkflowPayload: []byte(`
+on: push
+jobs:
+  test:
+    runs-on: [self-hosted, "${{ matrix.os }}", "linux-${{ vars.ARCH }}", "${{ env.LABEL }}"]
+    strategy:
+      matrix:
+        os: [ubuntu-22.04]
+    steps:
+      - run: echo
+`),
+	}
+	workflow, jobID, err := generateWorkflow(task)
+	require.NoError(t, err)
+	job := workflow.GetJob(jobID)
+
+	assert.Equal(t,
+		[]string{"self-hosted", "ubuntu-22.04", "linux-arm64", "${{ env.LABEL }}"},
+		evaluateRunsOn(newJobInterpreter(task, jobID, job), job.RunsOn()),
+	)
+}
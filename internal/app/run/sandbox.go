@@ -0,0 +1,182 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/nektos/act/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/labels"
+	"gitea.com/gitea/act_runner/internal/pkg/report"
+	"gitea.com/gitea/act_runner/internal/pkg/sandbox"
+)
+
+// sandboxDestroyTimeout is how long to wait for a sandbox to be destroyed after its task.
+const sandboxDestroyTimeout = 5 * time.Minute
+
+// sandboxJob is what the sandbox worker needs to run a job, it's written to its stdin as JSON.
+type sandboxJob struct {
+	Task     json.RawMessage   `json:"task"` // Task is the task encoded by protojson.
+	Config   *config.Config    `json:"config"`
+	Envs     map[string]string `json:"envs"`
+	Address  string            `json:"address"`
+	Dir      string            `json:"dir"` // Dir is the directory of the task in the sandbox.
+	Deadline time.Time         `json:"deadline"`
+}
+
+// sandboxEvent is a log entry of the job, or its result, written to the stdout of the sandbox worker as a JSON line.
+type sandboxEvent struct {
+	Time    time.Time       `json:"time"`
+	Level   log.Level       `json:"level"`
+	Message string          `json:"message"`
+	Data    map[string]any  `json:"data,omitempty"`
+	Logf    bool            `json:"logf,omitempty"` // Logf reports whether the message has been logged by jobReporter.Logf.
+	Summary *sandboxSummary `json:"summary,omitempty"`
+	Result  *sandboxResult  `json:"result,omitempty"`
+}
+
+// sandboxSummary is the summary of a step passed to jobReporter.AddSummary.
+type sandboxSummary struct {
+	Step    int    `json:"step"`
+	Content string `json:"content"`
+}
+
+// sandboxResult is the last event of the sandbox worker.
+type sandboxResult struct {
+	Outputs map[string]string `json:"outputs,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// runSandbox runs the job of task in a new sandbox for label, with the runner itself as the sandbox worker.
+func (r *Runner) runSandbox(ctx context.Context, task *runnerv1.Task, job *model.Job, reporter *report.Reporter, cfg *config.Config, envs map[string]string, label *labels.Label) error {
+	taskJSON, err := protojson.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	var services []sandbox.Service
+	for _, name := range slices.Sorted(maps.Keys(job.Services)) {
+		spec := job.Services[name]
+		services = append(services, sandbox.Service{
+			Name:  name,
+			Image: spec.Image,
+			Env:   spec.Env,
+			Cmd:   spec.Cmd,
+		})
+	}
+	if len(services) > 0 && label.Schema != labels.SchemeK8s {
+		reporter.Logf("service containers aren't supported by %s labels, ignored: %v", label.Schema, slices.Sorted(maps.Keys(job.Services)))
+		services = nil
+	}
+
+	sb, err := sandbox.New(label, cfg, fmt.Sprintf("gitea-actions-task-%d", task.Id), services)
+	if err != nil {
+		return err
+	}
+	sj := &sandboxJob{
+		Task:    taskJSON,
+		Config:  cfg,
+		Envs:    envs,
+		Address: r.client.Address(),
+		Dir:     sb.Dir(),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		sj.Deadline = deadline
+	}
+	stdin, err := json.Marshal(sj)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// ctx may be done already, but the sandbox must be removed anyway
+		ctx, cancel := context.WithTimeout(context.Background(), sandboxDestroyTimeout)
+		defer cancel()
+		if err := sb.Destroy(ctx); err != nil {
+			log.WithError(err).Errorf("failed to destroy the sandbox of task %d", task.Id)
+		}
+	}()
+
+	reporter.Logf("starting sandbox %s", label.Platform())
+	if err := sb.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start sandbox: %w", err)
+	}
+
+	stderr := log.WithField("task", task.Id).WriterLevel(log.DebugLevel)
+	defer stderr.Close()
+	pr, pw := io.Pipe()
+	execErr := make(chan error, 1)
+	go func() {
+		err := sb.Run(ctx, stdin, pw, stderr)
+		pw.Close()
+		execErr <- err
+	}()
+
+	result := readSandboxEvents(pr, reporter)
+	if err := <-execErr; result == nil {
+		if err == nil {
+			err = errors.New("no result")
+		}
+		return fmt.Errorf("sandbox worker failed: %w", err)
+	}
+	reporter.SetOutputs(result.Outputs)
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// readSandboxEvents passes the events read from r to reporter until EOF, and returns the result if there is.
+func readSandboxEvents(r io.Reader, reporter jobReporter) *sandboxResult {
+	var result *sandboxResult
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] != '{' {
+			// the sandbox may mix the logs of the worker with the events
+			log.Debugf("sandbox worker: %s", trimmed)
+		} else if len(trimmed) > 0 {
+			event := &sandboxEvent{}
+			if err := json.Unmarshal(line, event); err != nil {
+				log.WithError(err).Warnf("ignored invalid event of sandbox worker: %q", line)
+			} else if event.Result != nil {
+				result = event.Result
+			} else if event.Logf {
+				reporter.Logf("%s", event.Message)
+			} else if event.Summary != nil {
+				reporter.AddSummary(event.Summary.Step, event.Summary.Content)
+			} else {
+				// JSON has no integers, but the reporter needs the step number as an int
+				if v, ok := event.Data["stepNumber"].(float64); ok {
+					event.Data["stepNumber"] = int(v)
+				}
+				_ = reporter.Fire(&log.Entry{
+					Time:    event.Time,
+					Level:   event.Level,
+					Message: event.Message,
+					Data:    event.Data,
+				})
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.WithError(err).Warn("failed to read events of sandbox worker")
+			}
+			return result
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nektos/act/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordReporter struct {
+	entries   []*log.Entry
+	logs      []string
+	outputs   map[string]string
+	summaries map[int]string
+}
+
+func (r *recordReporter) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (r *recordReporter) Fire(entry *log.Entry) error {
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *recordReporter) Logf(format string, a ...interface{}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, a...))
+}
+
+func (r *recordReporter) SetOutputs(outputs map[string]string) {
+	r.outputs = outputs
+}
+
+func (r *recordReporter) AddSummary(step int, summary string) {
+	if r.summaries == nil {
+		r.summaries = map[int]string{}
+	}
+	r.summaries[step] += summary
+}
+
+func TestSandboxEvents(t *testing.T) {
+	var buf bytes.Buffer
+	worker := &eventReporter{encoder: json.NewEncoder(&buf)}
+	now := time.Now().Round(0)
+
+	worker.Logf("workflow %s", "prepared")
+	// the log of the worker may be mixed with the events
+	buf.WriteString("time=\"2024-01-01T00:00:00Z\" level=info msg=\"task 1 repo is o/r\"\n")
+	require.NoError(t, worker.Fire(&log.Entry{
+		Time:    now,
+		Level:   log.InfoLevel,
+		Message: "hello",
+		Data: log.Fields{
+			"stage":      "Main",
+			"stepNumber": 1,
+			"raw_output": true,
+		},
+	}))
+	require.NoError(t, worker.Fire(&log.Entry{
+		Time:    now,
+		Level:   log.ErrorLevel,
+		Message: "step failed",
+		Data: log.Fields{
+			"stage":      "Main",
+			"stepNumber": 1,
+			"stepResult": model.StepStatusFailure,
+			"error":      errors.New("exit code 1"),
+		},
+	}))
+	worker.AddSummary(1, "# Tests\n")
+	require.NoError(t, worker.write(&sandboxEvent{Result: &sandboxResult{
+		Outputs: map[string]string{"version": "1.0"},
+		Error:   "job failed",
+	}}))
+	buf.WriteString("not an event\n")
+
+	reporter := &recordReporter{}
+	result := readSandboxEvents(&buf, reporter)
+	require.NotNil(t, result)
+	assert.Equal(t, "job failed", result.Error)
+	assert.Equal(t, map[string]string{"version": "1.0"}, result.Outputs)
+
+	assert.Equal(t, []string{"workflow prepared"}, reporter.logs)
+	assert.Equal(t, map[int]string{1: "# Tests\n"}, reporter.summaries)
+	require.Len(t, reporter.entries, 2)
+	assert.True(t, reporter.entries[0].Time.Equal(now))
+	assert.Equal(t, "hello", reporter.entries[0].Message)
+	assert.Equal(t, log.Fields{"stage": "Main", "stepNumber": 1, "raw_output": true}, reporter.entries[0].Data)
+	assert.Equal(t, log.ErrorLevel, reporter.entries[1].Level)
+	assert.Equal(t, "failure", reporter.entries[1].Data["stepResult"])
+	assert.Equal(t, "exit code 1", reporter.entries[1].Data["error"])
+}
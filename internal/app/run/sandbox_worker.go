@@ -0,0 +1,121 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/nektos/act/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"gitea.com/gitea/act_runner/internal/pkg/labels"
+)
+
+// RunSandboxWorker runs the job read from stdin on this host, and writes its logs and result to stdout.
+// It's the counterpart of Runner.runSandbox, running inside the sandbox.
+func RunSandboxWorker(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+	job := &sandboxJob{}
+	if err := json.NewDecoder(stdin).Decode(job); err != nil {
+		return fmt.Errorf("failed to read job: %w", err)
+	}
+	task := &runnerv1.Task{}
+	if err := protojson.Unmarshal(job.Task, task); err != nil {
+		return fmt.Errorf("failed to read task: %w", err)
+	}
+	if !job.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, job.Deadline)
+		defer cancel()
+	}
+
+	// the directories of the runner host don't exist in the sandbox, and the ones of the task are removed with it
+	job.Config.Host.WorkdirParent = filepath.Join(job.Dir, "act")
+
+	reporter := &eventReporter{encoder: json.NewEncoder(stdout)}
+	result := &sandboxResult{}
+	if err := runSandboxJob(ctx, task, reporter, job); err != nil {
+		result.Error = err.Error()
+	}
+	result.Outputs = reporter.outputs
+	return reporter.write(&sandboxEvent{Time: time.Now(), Level: log.InfoLevel, Result: result})
+}
+
+func runSandboxJob(ctx context.Context, task *runnerv1.Task, reporter *eventReporter, job *sandboxJob) error {
+	workflow, jobID, err := generateWorkflow(task)
+	if err != nil {
+		return err
+	}
+	plan, err := model.CombineWorkflowPlanner(workflow).PlanJob(jobID)
+	if err != nil {
+		return err
+	}
+	host := &labels.Label{Name: "sandbox", Schema: labels.SchemeHost}
+	return execute(ctx, task, plan, jobID, workflow.GetJob(jobID), reporter, job.Config, job.Envs, host, job.Address, "")
+}
+
+// eventReporter is the jobReporter of the sandbox worker, it writes sandboxEvent to the runner.
+type eventReporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	outputs map[string]string
+}
+
+func (r *eventReporter) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (r *eventReporter) Fire(entry *log.Entry) error {
+	data := make(map[string]any, len(entry.Data))
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		} else if _, err := json.Marshal(v); err != nil {
+			v = fmt.Sprint(v)
+		}
+		data[k] = v
+	}
+	return r.write(&sandboxEvent{
+		Time:    entry.Time,
+		Level:   entry.Level,
+		Message: entry.Message,
+		Data:    data,
+	})
+}
+
+func (r *eventReporter) Logf(format string, a ...interface{}) {
+	_ = r.write(&sandboxEvent{
+		Time:    time.Now(),
+		Level:   log.InfoLevel,
+		Message: fmt.Sprintf(format, a...),
+		Logf:    true,
+	})
+}
+
+func (r *eventReporter) SetOutputs(outputs map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outputs = outputs
+}
+
+func (r *eventReporter) AddSummary(step int, summary string) {
+	_ = r.write(&sandboxEvent{
+		Time:    time.Now(),
+		Level:   log.InfoLevel,
+		Summary: &sandboxSummary{Step: step, Content: summary},
+	})
+}
+
+func (r *eventReporter) write(event *sandboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.encoder.Encode(event)
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+
+	"gitea.com/gitea/act_runner/internal/pkg/labels"
+	"gitea.com/gitea/act_runner/internal/pkg/reaper"
+	"gitea.com/gitea/act_runner/internal/pkg/report"
+)
+
+const (
+	// containerActPath is where act keeps the files of the workflow commands in job containers.
+	containerActPath = "/var/run/act"
+	// summaryFile is the file of $GITHUB_STEP_SUMMARY in the act path, act empties it before each step.
+	summaryFile = "workflow/SUMMARY.md"
+)
+
+// summaryReader reads the summary written by the last step, up to report.MaxSummarySize+1 bytes.
+type summaryReader func(ctx context.Context) ([]byte, error)
+
+// summaryHook passes the log entries of a job to its reporter, and the summary of each step once it has run.
+type summaryHook struct {
+	jobReporter
+	ctx  context.Context
+	read summaryReader
+}
+
+func (h *summaryHook) Fire(entry *log.Entry) error {
+	err := h.jobReporter.Fire(entry)
+
+	step, ok := entry.Data["stepNumber"].(int)
+	result, done := entry.Data["stepResult"]
+	// the summary isn't emptied before skipped steps, it's the one of the previous step
+	if !ok || !done || fmt.Sprint(result) == "skipped" {
+		return err
+	}
+	summary, readErr := h.read(h.ctx)
+	if readErr != nil {
+		log.Debugf("cannot read the summary of step %d: %v", step, readErr)
+	} else if len(summary) > 0 {
+		h.jobReporter.AddSummary(step, string(summary))
+	}
+	return err
+}
+
+// newSummaryReader returns the reader of the summaries of the job of task id with label, act keeps them in
+// the job container, or in actionCacheDir on the host. The containers are the ones labeled with owner, if it isn't empty.
+// The returned function releases the reader once the job has finished.
+func newSummaryReader(id int64, label *labels.Label, actionCacheDir, owner string) (summaryReader, func()) {
+	if label.Schema == labels.SchemeHost {
+		return hostSummaryReader(actionCacheDir), func() {}
+	}
+	return containerSummaryReader(fmt.Sprintf("^/%s%d_", reaper.NamePrefix, id), owner)
+}
+
+// hostSummaryReader reads the summary in the act path of the job, which is in a random directory of actionCacheDir.
+// The directory must be used by a single task.
+func hostSummaryReader(actionCacheDir string) summaryReader {
+	return func(context.Context) ([]byte, error) {
+		files, err := filepath.Glob(filepath.Join(actionCacheDir, "*", "act", filepath.FromSlash(summaryFile)))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) != 1 {
+			return nil, fmt.Errorf("found %d summary files in %s", len(files), actionCacheDir)
+		}
+		f, err := os.Open(files[0])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, report.MaxSummarySize+1))
+	}
+}
+
+// containerSummaryReader reads the summary in the job container whose name matches the pattern name and which is labeled
+// with owner, the job container is told from the service containers by the act path mounted.
+// The returned function closes the client of the docker daemon.
+func containerSummaryReader(name, owner string) (summaryReader, func()) {
+	var mu sync.Mutex
+	var cli client.APIClient
+	var id string
+	read := func(ctx context.Context) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cli == nil {
+			c, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+			if err != nil {
+				return nil, err
+			}
+			cli = c
+		}
+		if id == "" {
+			args := filters.NewArgs(filters.Arg("name", name))
+			if owner != "" {
+				args.Add("label", reaper.OwnerLabel+"="+owner)
+			}
+			containers, err := cli.ContainerList(ctx, container.ListOptions{Filters: args})
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range containers {
+				for _, m := range c.Mounts {
+					if m.Destination == containerActPath {
+						id = c.ID
+					}
+				}
+			}
+			if id == "" {
+				return nil, errors.New("the job container isn't found")
+			}
+		}
+
+		rc, _, err := cli.CopyFromContainer(ctx, id, containerActPath+"/"+summaryFile)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		tr := tar.NewReader(rc)
+		if _, err := tr.Next(); err != nil {
+			return nil, err
+		}
+		return io.ReadAll(io.LimitReader(tr, report.MaxSummarySize+1))
+	}
+	closeClient := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if cli != nil {
+			_ = cli.Close()
+			cli = nil
+			id = ""
+		}
+	}
+	return read, closeClient
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nektos/act/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.com/gitea/act_runner/internal/pkg/labels"
+	"gitea.com/gitea/act_runner/internal/pkg/report"
+)
+
+func TestSummaryHook(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "0123456789abcdef", "act", "workflow", "SUMMARY.md")
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+
+	reporter := &recordReporter{}
+	hook := &summaryHook{jobReporter: reporter, ctx: context.Background(), read: hostSummaryReader(dir)}
+	fire := func(step int, result fmt.Stringer) {
+		require.NoError(t, hook.Fire(&log.Entry{Data: log.Fields{"stage": "Main", "stepNumber": step, "stepResult": result}}))
+	}
+
+	require.NoError(t, os.WriteFile(file, []byte("# Coverage\n\n| pkg | 80% |\n"), 0o644))
+	require.NoError(t, hook.Fire(&log.Entry{Message: "output", Data: log.Fields{"stage": "Main", "stepNumber": 0, "raw_output": true}}))
+	fire(0, model.StepStatusSuccess)
+	// the summary of skipped steps is the one of the previous step
+	fire(1, model.StepStatusSkipped)
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	fire(2, model.StepStatusFailure)
+
+	assert.Len(t, reporter.entries, 4)
+	assert.Equal(t, map[int]string{0: "# Coverage\n\n| pkg | 80% |\n"}, reporter.summaries)
+}
+
+func TestHostSummaryReader(t *testing.T) {
+	dir := t.TempDir()
+	_, err := hostSummaryReader(dir)(context.Background())
+	assert.ErrorContains(t, err, "found 0 summary files")
+
+	file := filepath.Join(dir, "0123456789abcdef", "act", "workflow", "SUMMARY.md")
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+	require.NoError(t, os.WriteFile(file, []byte(strings.Repeat("a", report.MaxSummarySize+10)), 0o644))
+	summary, err := hostSummaryReader(dir)(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, summary, report.MaxSummarySize+1)
+}
+
+func TestContainerSummaryReader(t *testing.T) {
+	var filters []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			filters = append(filters, r.URL.Query().Get("filters"))
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]map[string]any{
+				{"Id": "service", "Mounts": []map[string]any{}},
+				{"Id": "job", "Mounts": []map[string]any{{"Destination": containerActPath}}},
+			})
+		case strings.HasSuffix(r.URL.Path, "/containers/job/archive"):
+			w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString([]byte(`{"name":"SUMMARY.md"}`)))
+			tw := tar.NewWriter(w)
+			content := []byte("# Coverage\n")
+			_ = tw.WriteHeader(&tar.Header{Name: "SUMMARY.md", Mode: 0o644, Size: int64(len(content))})
+			_, _ = tw.Write(content)
+			_ = tw.Close()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+server.Listener.Addr().String())
+	t.Setenv("DOCKER_API_VERSION", "1.43")
+
+	read, closeReader := newSummaryReader(42, &labels.Label{Schema: labels.SchemeDocker}, "", "runner-uuid")
+	for i := 0; i < 2; i++ {
+		summary, err := read(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "# Coverage\n", string(summary))
+	}
+	// the job container is looked up once, among the containers of the task started by the runner
+	require.Len(t, filters, 1)
+	assert.JSONEq(t, `{"label":{"com.gitea.act-runner.uuid=runner-uuid":true},"name":{"^/GITEA-ACTIONS-TASK-42_":true}}`, filters[0])
+	closeReader()
+	closeReader()
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+// taskWorkdirs returns the directory holding the workspaces of the tasks of host labels.
+// It's separated by runner, since runners of the same user share the host directory by default.
+func (r *Runner) taskWorkdirs(cfg *config.Config) string {
+	return filepath.Join(cfg.Host.WorkdirParent, "tasks", r.uuid)
+}
+
+// taskWorkdir returns the workspace of task id, which is removed after the task.
+func (r *Runner) taskWorkdir(cfg *config.Config, id int64) string {
+	return filepath.Join(r.taskWorkdirs(cfg), strconv.FormatInt(id, 10))
+}
+
+// SweepWorkdirs removes the workspaces of the tasks which aren't running, they're left behind if the runner crashed.
+func (r *Runner) SweepWorkdirs() {
+	cfg, _, _, _ := r.snapshot()
+	dir := r.taskWorkdirs(cfg)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("cannot sweep the workspaces of tasks: %v", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		id, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := r.runningTasks.Load(id); ok {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			log.Warnf("cannot remove the workspace of task %d: %v", id, err)
+			continue
+		}
+		log.Infof("removed the workspace of task %d left behind", id)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+func TestRunner_SweepWorkdirs(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Host.WorkdirParent = t.TempDir()
+	r := &Runner{uuid: "runner-uuid", cfg: cfg}
+
+	for _, id := range []int64{41, 42} {
+		require.NoError(t, os.MkdirAll(filepath.Join(r.taskWorkdir(cfg, id), "hostexecutor"), 0o755))
+	}
+	// the directories which aren't workspaces and the ones of other runners are kept
+	require.NoError(t, os.MkdirAll(filepath.Join(r.taskWorkdirs(cfg), "tool_cache"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(cfg.Host.WorkdirParent, "tasks", "other-uuid", "43"), 0o755))
+	r.runningTasks.Store(int64(42), struct{}{})
+
+	r.SweepWorkdirs()
+
+	assert.NoDirExists(t, r.taskWorkdir(cfg, 41))
+	assert.DirExists(t, r.taskWorkdir(cfg, 42))
+	assert.DirExists(t, filepath.Join(r.taskWorkdirs(cfg), "tool_cache"))
+	assert.DirExists(t, filepath.Join(cfg.Host.WorkdirParent, "tasks", "other-uuid", "43"))
+	assert.Equal(t, filepath.Join(cfg.Host.WorkdirParent, "tasks", "runner-uuid", "42"), r.taskWorkdir(cfg, 42))
+}
@@ -21,16 +21,34 @@ type Log struct {
 
 // Runner represents the configuration for the runner.
 type Runner struct {
-	File            string            `yaml:"file"`             // File specifies the file path for the runner.
-	Capacity        int               `yaml:"capacity"`         // Capacity specifies the capacity of the runner.
-	Envs            map[string]string `yaml:"envs"`             // Envs stores environment variables for the runner.
-	EnvFile         string            `yaml:"env_file"`         // EnvFile specifies the path to the file containing environment variables for the runner.
-	Timeout         time.Duration     `yaml:"timeout"`          // Timeout specifies the duration for runner timeout.
-	ShutdownTimeout time.Duration     `yaml:"shutdown_timeout"` // ShutdownTimeout specifies the duration to wait for running jobs to complete during a shutdown of the runner.
-	Insecure        bool              `yaml:"insecure"`         // Insecure indicates whether the runner operates in an insecure mode.
-	FetchTimeout    time.Duration     `yaml:"fetch_timeout"`    // FetchTimeout specifies the timeout duration for fetching resources.
-	FetchInterval   time.Duration     `yaml:"fetch_interval"`   // FetchInterval specifies the interval duration for fetching resources.
-	Labels          []string          `yaml:"labels"`           // Labels specify the labels of the runner. Labels are declared on each startup
+	File            string            `yaml:"file"`              // File specifies the file path for the runner.
+	Capacity        int               `yaml:"capacity"`          // Capacity specifies the capacity of the runner.
+	Envs            map[string]string `yaml:"envs"`              // Envs stores environment variables for the runner.
+	EnvFile         string            `yaml:"env_file"`          // EnvFile specifies the path to the file containing environment variables for the runner.
+	Timeout         time.Duration     `yaml:"timeout"`           // Timeout specifies the duration for runner timeout.
+	ShutdownTimeout time.Duration     `yaml:"shutdown_timeout"`  // ShutdownTimeout specifies the duration to wait for running jobs to complete during a shutdown of the runner.
+	Insecure        bool              `yaml:"insecure"`          // Insecure indicates whether the runner operates in an insecure mode.
+	FetchTimeout    time.Duration     `yaml:"fetch_timeout"`     // FetchTimeout specifies the timeout duration for fetching resources.
+	FetchInterval   time.Duration     `yaml:"fetch_interval"`    // FetchInterval specifies the interval duration for fetching resources.
+	FetchBackoffMax time.Duration     `yaml:"fetch_backoff_max"` // FetchBackoffMax specifies the maximum delay between fetching resources after consecutive errors.
+	Labels          []string          `yaml:"labels"`            // Labels specify the labels of the runner. Labels are declared on each startup
+	LabelSets       []LabelSet        `yaml:"label_sets"`        // LabelSets specify the platforms of jobs that require several labels.
+	NoMatch         string            `yaml:"no_match"`          // NoMatch specifies what to do with jobs whose labels don't match, see the NoMatch constants.
+	DefaultLabel    string            `yaml:"default_label"`     // DefaultLabel specifies the label to run jobs whose labels don't match, if NoMatch is NoMatchDefault.
+	SpoolDir        string            `yaml:"spool_dir"`         // SpoolDir specifies the directory keeping the logs and states of tasks until Gitea has received them.
+}
+
+// The values of Runner.NoMatch.
+const (
+	NoMatchFail    = "fail"    // NoMatchFail fails jobs whose labels don't match.
+	NoMatchDefault = "default" // NoMatchDefault runs jobs whose labels don't match with Runner.DefaultLabel.
+	NoMatchLegacy  = "legacy"  // NoMatchLegacy runs jobs whose labels don't match with the gitea/runner-images:ubuntu-latest image.
+)
+
+// LabelSet represents a combination of labels which together select a platform.
+type LabelSet struct {
+	Labels   []string `yaml:"labels"`   // Labels specify the names of the labels, all of them must be required by a job.
+	Platform string   `yaml:"platform"` // Platform specifies the platform like the part of a label after the name, e.g. "docker://node:18" or "host".
 }
 
 // Cache represents the configuration for caching.
@@ -53,6 +71,94 @@ type Container struct {
 	DockerHost    string   `yaml:"docker_host"`    // DockerHost specifies the Docker host. It overrides the value specified in environment variable DOCKER_HOST.
 	ForcePull     bool     `yaml:"force_pull"`     // Pull docker image(s) even if already present
 	ForceRebuild  bool     `yaml:"force_rebuild"`  // Rebuild docker image(s) even if already present
+	PodmanHost    string   `yaml:"podman_host"`    // PodmanHost specifies the API socket of Podman for podman labels. It overrides the value specified in environment variable CONTAINER_HOST.
+	PodmanUserns  string   `yaml:"podman_userns"`  // PodmanUserns specifies the user namespace mode of the pods and the job containers of podman labels, like "keep-id".
+
+	Limits   ContainerLimits             `yaml:"limits"`   // Limits specifies the resource limits of the job and service containers.
+	Images   ImagePolicy                 `yaml:"images"`   // Images specifies the images which workflows can use.
+	Egress   EgressPolicy                `yaml:"egress"`   // Egress specifies the destinations which the containers of jobs can connect to.
+	Profiles map[string]ContainerProfile `yaml:"profiles"` // Profiles specifies the overrides of the fields above for the jobs of some labels, by label name.
+
+	Reaper Reaper `yaml:"reaper"` // Reaper specifies the removal of the containers, networks and volumes left behind by tasks.
+}
+
+// Reaper represents the configuration for removing the containers, networks and volumes of tasks which aren't running anymore.
+type Reaper struct {
+	Enabled     *bool         `yaml:"enabled"`      // Enabled indicates whether the reaper is enabled. It is a pointer to distinguish between false and not set. If not set, it will be true.
+	Interval    time.Duration `yaml:"interval"`     // Interval specifies how often the reaper runs, besides at startup.
+	GracePeriod time.Duration `yaml:"grace_period"` // GracePeriod specifies how long the resources are kept after their creation, since their task may be starting.
+}
+
+// ContainerLimits are the resource limits of the containers of a job, the zero values mean unlimited.
+// The options of workflows can't override them.
+type ContainerLimits struct {
+	CPUs     float64  `yaml:"cpus"`      // CPUs is the number of CPUs a container can use, like 1.5.
+	Memory   string   `yaml:"memory"`    // Memory is the memory limit of a container, like "4g". Swap isn't allowed beyond it.
+	Pids     int64    `yaml:"pids"`      // Pids is the maximum number of processes in a container.
+	Ulimits  []string `yaml:"ulimits"`   // Ulimits are the ulimits of a container, like "nofile=1024:2048".
+	Tmpfs    []string `yaml:"tmpfs"`     // Tmpfs are the tmpfs mounts of a container with their sizes, like "/tmp:size=1g".
+	DiskSize string   `yaml:"disk_size"` // DiskSize is the size of the writable layer of a container, like "20g". It requires a storage driver supporting it.
+}
+
+// ImagePolicy restricts the images of the job containers, the service containers and the docker actions of workflows.
+// A pattern is a glob like "docker.io/library/*", or a regular expression prefixed by "regex:".
+// Patterns are matched with both the image as written and its normalized name, like "node:18" and "docker.io/library/node:18".
+type ImagePolicy struct {
+	Allow         []string `yaml:"allow"`          // Allow are the patterns of the allowed images. All images are allowed if it's empty.
+	Deny          []string `yaml:"deny"`           // Deny are the patterns of the denied images, they take precedence over Allow.
+	RequireDigest bool     `yaml:"require_digest"` // RequireDigest requires the images to be pinned by digest, like "node@sha256:...".
+}
+
+// EgressPolicy restricts the destinations which the containers of a job can connect to.
+// The runner creates a network for each job and filters its traffic with iptables, so Container.Network has to be empty.
+type EgressPolicy struct {
+	Enabled  bool     `yaml:"enabled"`  // Enabled enables the policy.
+	Allow    []string `yaml:"allow"`    // Allow are the CIDRs, IP addresses and host names the containers can connect to, besides the Gitea instance and the cache server.
+	Iptables string   `yaml:"iptables"` // Iptables is the iptables command, "iptables" if it's empty.
+}
+
+// ContainerProfile overrides the configuration of the containers of a label, the fields which are not set are inherited.
+type ContainerProfile struct {
+	Network      *string  `yaml:"network"`       // Network replaces Container.Network if it's set.
+	Privileged   *bool    `yaml:"privileged"`    // Privileged replaces Container.Privileged if it's set.
+	Options      *string  `yaml:"options"`       // Options replaces Container.Options if it's set.
+	ValidVolumes []string `yaml:"valid_volumes"` // ValidVolumes replaces Container.ValidVolumes if it's set, even to an empty sequence.
+
+	Limits *ContainerLimits `yaml:"limits"` // Limits replaces Container.Limits as a whole if it's set.
+	Images *ImagePolicy     `yaml:"images"` // Images replaces Container.Images as a whole if it's set.
+	Egress *EgressPolicy    `yaml:"egress"` // Egress replaces Container.Egress as a whole if it's set.
+}
+
+// ForLabel returns the configuration for the containers of label, with its profile applied.
+// The label of a label set is named after the names of the set joined by "+".
+func (c *Container) ForLabel(label string) Container {
+	merged := *c
+	profile, ok := c.Profiles[label]
+	if !ok {
+		return merged
+	}
+	if profile.Network != nil {
+		merged.Network = *profile.Network
+	}
+	if profile.Privileged != nil {
+		merged.Privileged = *profile.Privileged
+	}
+	if profile.Options != nil {
+		merged.Options = *profile.Options
+	}
+	if profile.ValidVolumes != nil {
+		merged.ValidVolumes = profile.ValidVolumes
+	}
+	if profile.Limits != nil {
+		merged.Limits = *profile.Limits
+	}
+	if profile.Images != nil {
+		merged.Images = *profile.Images
+	}
+	if profile.Egress != nil {
+		merged.Egress = *profile.Egress
+	}
+	return merged
 }
 
 // Host represents the configuration for the host.
@@ -60,13 +166,64 @@ type Host struct {
 	WorkdirParent string `yaml:"workdir_parent"` // WorkdirParent specifies the parent directory for the host's working directory.
 }
 
+// LXC represents the configuration for LXC and Incus system containers.
+type LXC struct {
+	Command      string        `yaml:"command"`       // Command specifies the client to manage the containers, "incus" or "lxc" of LXD.
+	Profiles     []string      `yaml:"profiles"`      // Profiles specifies the profiles applied to the containers, instead of the ones of the cloned instance.
+	StartTimeout time.Duration `yaml:"start_timeout"` // StartTimeout specifies how long to wait for a container to boot.
+}
+
+// SSH represents the configuration for remote hosts reached by SSH.
+type SSH struct {
+	KeyFile        string            `yaml:"key_file"`         // KeyFile specifies the private key to log in to the hosts.
+	HostKeys       map[string]string `yaml:"host_keys"`        // HostKeys specifies the private keys of some hosts, by "user@host" or "host", instead of KeyFile.
+	KnownHostsFile string            `yaml:"known_hosts_file"` // KnownHostsFile specifies the known_hosts file to verify the hosts.
+	ConnectTimeout time.Duration     `yaml:"connect_timeout"`  // ConnectTimeout specifies the timeout to connect to a host.
+}
+
+// Kubernetes represents the configuration for Kubernetes pods.
+type Kubernetes struct {
+	APIServer    string        `yaml:"api_server"`    // APIServer specifies the URL of the API server, the one of the cluster the runner runs in if it's empty.
+	TokenFile    string        `yaml:"token_file"`    // TokenFile specifies the bearer token to access the API server.
+	CAFile       string        `yaml:"ca_file"`       // CAFile specifies the certificate authority of the API server.
+	Namespace    string        `yaml:"namespace"`     // Namespace specifies the namespace of the pods.
+	PodTemplate  string        `yaml:"pod_template"`  // PodTemplate specifies a YAML file of a pod which the pods are created from.
+	RunnerImage  string        `yaml:"runner_image"`  // RunnerImage specifies the image which act_runner is copied from into the pods.
+	StartTimeout time.Duration `yaml:"start_timeout"` // StartTimeout specifies how long to wait for a pod to start.
+}
+
+// Metrics represents the configuration for the Prometheus metrics endpoint.
+type Metrics struct {
+	Enabled bool   `yaml:"enabled"` // Enabled indicates whether the metrics endpoint is enabled.
+	Addr    string `yaml:"addr"`    // Addr specifies the address the metrics endpoint listens on.
+}
+
+// Health represents the configuration for the health and readiness endpoints.
+type Health struct {
+	Enabled        bool   `yaml:"enabled"`         // Enabled indicates whether the health and readiness endpoints are enabled.
+	Addr           string `yaml:"addr"`            // Addr specifies the address the health and readiness endpoints listen on.
+	FetchIntervals int    `yaml:"fetch_intervals"` // FetchIntervals specifies how many fetch intervals may pass without a successful fetch before the runner is not ready.
+}
+
+// Admin represents the configuration for the admin endpoints.
+type Admin struct {
+	Enabled bool   `yaml:"enabled"` // Enabled indicates whether the admin endpoints are enabled.
+	Addr    string `yaml:"addr"`    // Addr specifies the address the admin endpoints listen on.
+}
+
 // Config represents the overall configuration.
 type Config struct {
-	Log       Log       `yaml:"log"`       // Log represents the configuration for logging.
-	Runner    Runner    `yaml:"runner"`    // Runner represents the configuration for the runner.
-	Cache     Cache     `yaml:"cache"`     // Cache represents the configuration for caching.
-	Container Container `yaml:"container"` // Container represents the configuration for the container.
-	Host      Host      `yaml:"host"`      // Host represents the configuration for the host.
+	Log        Log        `yaml:"log"`        // Log represents the configuration for logging.
+	Runner     Runner     `yaml:"runner"`     // Runner represents the configuration for the runner.
+	Cache      Cache      `yaml:"cache"`      // Cache represents the configuration for caching.
+	Container  Container  `yaml:"container"`  // Container represents the configuration for the container.
+	Host       Host       `yaml:"host"`       // Host represents the configuration for the host.
+	LXC        LXC        `yaml:"lxc"`        // LXC represents the configuration for LXC and Incus system containers.
+	SSH        SSH        `yaml:"ssh"`        // SSH represents the configuration for remote hosts reached by SSH.
+	Kubernetes Kubernetes `yaml:"kubernetes"` // Kubernetes represents the configuration for Kubernetes pods.
+	Metrics    Metrics    `yaml:"metrics"`    // Metrics represents the configuration for the Prometheus metrics endpoint.
+	Health     Health     `yaml:"health"`     // Health represents the configuration for the health and readiness endpoints.
+	Admin      Admin      `yaml:"admin"`      // Admin represents the configuration for the admin endpoints.
 }
 
 // LoadDefault returns the default configuration.
@@ -83,6 +240,9 @@ func LoadDefault(file string) (*Config, error) {
 		}
 	}
 	compatibleWithOldEnvs(file != "", cfg)
+	if err := applyEnvOverrides(cfg, os.Environ()); err != nil {
+		return nil, err
+	}
 
 	if cfg.Runner.EnvFile != "" {
 		if stat, err := os.Stat(cfg.Runner.EnvFile); err == nil && !stat.IsDir() {
@@ -105,6 +265,9 @@ func LoadDefault(file string) (*Config, error) {
 	if cfg.Runner.File == "" {
 		cfg.Runner.File = ".runner"
 	}
+	if cfg.Runner.SpoolDir == "" {
+		cfg.Runner.SpoolDir = filepath.Join(filepath.Dir(cfg.Runner.File), ".spool")
+	}
 	if cfg.Runner.Capacity <= 0 {
 		cfg.Runner.Capacity = 1
 	}
@@ -121,6 +284,16 @@ func LoadDefault(file string) (*Config, error) {
 			cfg.Cache.Dir = filepath.Join(home, ".cache", "actcache")
 		}
 	}
+	if cfg.Container.Reaper.Enabled == nil {
+		b := true
+		cfg.Container.Reaper.Enabled = &b
+	}
+	if cfg.Container.Reaper.Interval <= 0 {
+		cfg.Container.Reaper.Interval = 10 * time.Minute
+	}
+	if cfg.Container.Reaper.GracePeriod <= 0 {
+		cfg.Container.Reaper.GracePeriod = 10 * time.Minute
+	}
 	if cfg.Container.WorkdirParent == "" {
 		cfg.Container.WorkdirParent = "workspace"
 	}
@@ -128,12 +301,49 @@ func LoadDefault(file string) (*Config, error) {
 		home, _ := os.UserHomeDir()
 		cfg.Host.WorkdirParent = filepath.Join(home, ".cache", "act")
 	}
+	if cfg.LXC.Command == "" {
+		cfg.LXC.Command = "incus"
+	}
+	if cfg.LXC.StartTimeout <= 0 {
+		cfg.LXC.StartTimeout = 2 * time.Minute
+	}
+	if cfg.SSH.KnownHostsFile == "" {
+		home, _ := os.UserHomeDir()
+		cfg.SSH.KnownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if cfg.SSH.ConnectTimeout <= 0 {
+		cfg.SSH.ConnectTimeout = 30 * time.Second
+	}
+	if cfg.Kubernetes.StartTimeout <= 0 {
+		cfg.Kubernetes.StartTimeout = 5 * time.Minute
+	}
 	if cfg.Runner.FetchTimeout <= 0 {
 		cfg.Runner.FetchTimeout = 5 * time.Second
 	}
 	if cfg.Runner.FetchInterval <= 0 {
 		cfg.Runner.FetchInterval = 2 * time.Second
 	}
+	if cfg.Runner.NoMatch == "" {
+		cfg.Runner.NoMatch = NoMatchFail
+	}
+	if cfg.Runner.FetchBackoffMax <= 0 {
+		cfg.Runner.FetchBackoffMax = time.Minute
+	}
+	if cfg.Runner.FetchBackoffMax < cfg.Runner.FetchInterval {
+		cfg.Runner.FetchBackoffMax = cfg.Runner.FetchInterval
+	}
+	if cfg.Metrics.Addr == "" {
+		cfg.Metrics.Addr = "127.0.0.1:9101"
+	}
+	if cfg.Health.Addr == "" {
+		cfg.Health.Addr = ":8088"
+	}
+	if cfg.Health.FetchIntervals <= 0 {
+		cfg.Health.FetchIntervals = 5
+	}
+	if cfg.Admin.Addr == "" {
+		cfg.Admin.Addr = "127.0.0.1:8089"
+	}
 
 	// although `container.network_mode` will be deprecated, but we have to be compatible with it for now.
 	if cfg.Container.NetworkMode != "" && cfg.Container.Network == "" {
@@ -0,0 +1,47 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainer_ForLabel(t *testing.T) {
+	none := "none"
+	options := "--cpus 8 --memory 16g"
+	privileged := false
+	c := Container{
+		Network:      "bridge",
+		Privileged:   true,
+		Options:      "--add-host=gitea:10.0.0.1",
+		ValidVolumes: []string{"cache"},
+		Limits:       ContainerLimits{CPUs: 2, Memory: "4g"},
+		Profiles: map[string]ContainerProfile{
+			"build-large": {Options: &options, ValidVolumes: []string{"cache", "build-cache"}},
+			"untrusted":   {Network: &none, Privileged: &privileged, ValidVolumes: []string{}, Limits: &ContainerLimits{CPUs: 1}},
+		},
+	}
+
+	got := c.ForLabel("ubuntu-latest")
+	assert.Equal(t, "bridge", got.Network)
+	assert.True(t, got.Privileged)
+	assert.Equal(t, "--add-host=gitea:10.0.0.1", got.Options)
+	assert.Equal(t, []string{"cache"}, got.ValidVolumes)
+
+	got = c.ForLabel("build-large")
+	assert.Equal(t, "bridge", got.Network)
+	assert.True(t, got.Privileged)
+	assert.Equal(t, "--cpus 8 --memory 16g", got.Options)
+	assert.Equal(t, []string{"cache", "build-cache"}, got.ValidVolumes)
+	assert.Equal(t, ContainerLimits{CPUs: 2, Memory: "4g"}, got.Limits)
+
+	got = c.ForLabel("untrusted")
+	assert.Equal(t, "none", got.Network)
+	assert.False(t, got.Privileged)
+	assert.Equal(t, "--add-host=gitea:10.0.0.1", got.Options)
+	assert.Empty(t, got.ValidVolumes)
+	assert.Equal(t, ContainerLimits{CPUs: 1}, got.Limits)
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding fields of the configuration.
+// The path of the field follows the prefix, separated by "__", like ACT_RUNNER__RUNNER__CAPACITY for runner.capacity.
+// The keys of maps are part of the path too, like ACT_RUNNER__RUNNER__ENVS__FOO for the key FOO of runner.envs.
+const EnvPrefix = "ACT_RUNNER__"
+
+// applyEnvOverrides sets the fields of cfg from the environment variables with EnvPrefix in environ.
+// Lists are comma-separated, other values are parsed like YAML scalars.
+func applyEnvOverrides(cfg *Config, environ []string) error {
+	// apply in a stable order, so a map and one of its keys are applied predictably
+	sorted := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, EnvPrefix) {
+			sorted = append(sorted, kv)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, kv := range sorted {
+		key, value, _ := strings.Cut(kv, "=")
+		path := strings.Split(strings.TrimPrefix(key, EnvPrefix), "__")
+		if err := setEnvOverride(reflect.ValueOf(cfg).Elem(), path, value); err != nil {
+			return fmt.Errorf("env %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func setEnvOverride(v reflect.Value, path []string, value string) error {
+	if v.Kind() == reflect.Pointer && len(path) > 0 {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if len(path) == 0 {
+		return setEnvValue(v, value)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		name := strings.ToLower(path[0])
+		for i := 0; i < v.NumField(); i++ {
+			if tag := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]; tag == name {
+				return setEnvOverride(v.Field(i), path[1:], value)
+			}
+		}
+		return fmt.Errorf("unknown field %q", name)
+	case reflect.Map:
+		if len(path) > 1 || v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map path %q", strings.Join(path, "__"))
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := setEnvValue(elem, value); err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(path[0]), elem)
+		return nil
+	default:
+		return fmt.Errorf("%q is not a section or a map", strings.Join(path, "__"))
+	}
+}
+
+func setEnvValue(v reflect.Value, value string) error {
+	switch {
+	case v.Kind() == reflect.String:
+		// strings are taken literally, values like "yes" or "1.0" are not converted
+		v.SetString(value)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+		return nil
+	}
+
+	ptr := reflect.New(v.Type())
+	if err := yaml.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+		return fmt.Errorf("invalid value %q: %w", value, err)
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	cfg := &Config{
+		Runner: Runner{
+			Capacity: 1,
+			Envs:     map[string]string{"FOO": "foo"},
+		},
+		Container: Container{
+			Network: "bridge",
+		},
+	}
+
+	require.NoError(t, applyEnvOverrides(cfg, []string{
+		"PATH=/usr/bin",
+		"ACT_RUNNER__RUNNER__CAPACITY=4",
+		"ACT_RUNNER__RUNNER__FETCH_INTERVAL=10s",
+		"ACT_RUNNER__RUNNER__ENVS__BAR=bar=baz",
+		"ACT_RUNNER__RUNNER__LABELS=ubuntu-latest:docker://node:18, linux:host",
+		"ACT_RUNNER__CONTAINER__NETWORK=host",
+		"ACT_RUNNER__CACHE__ENABLED=false",
+		"ACT_RUNNER__CACHE__PORT=8088",
+		"ACT_RUNNER__LOG__LEVEL=no",
+	}))
+
+	assert.Equal(t, 4, cfg.Runner.Capacity)
+	assert.Equal(t, 10*time.Second, cfg.Runner.FetchInterval)
+	assert.Equal(t, map[string]string{"FOO": "foo", "BAR": "bar=baz"}, cfg.Runner.Envs)
+	assert.Equal(t, []string{"ubuntu-latest:docker://node:18", "linux:host"}, cfg.Runner.Labels)
+	assert.Equal(t, "host", cfg.Container.Network)
+	require.NotNil(t, cfg.Cache.Enabled)
+	assert.False(t, *cfg.Cache.Enabled)
+	assert.Equal(t, uint16(8088), cfg.Cache.Port)
+	assert.Equal(t, "no", cfg.Log.Level)
+}
+
+func TestApplyEnvOverrides_Invalid(t *testing.T) {
+	for _, env := range []string{
+		"ACT_RUNNER__RUNNER__FETCH_INTERVALL=2s",
+		"ACT_RUNNER__RUNNER__CAPACITY=many",
+		"ACT_RUNNER__RUNNER__CAPACITY__MAX=1",
+		"ACT_RUNNER__RUNNER__ENVS__FOO__BAR=1",
+	} {
+		t.Run(env, func(t *testing.T) {
+			assert.Error(t, applyEnvOverrides(&Config{}, []string{env}))
+		})
+	}
+}
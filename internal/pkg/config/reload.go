@@ -0,0 +1,45 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import "reflect"
+
+// restartRequired lists the fields which can't be changed without restarting the daemon.
+// Each function returns a pointer to the field.
+var restartRequired = []struct {
+	name  string
+	field func(c *Config) any
+}{
+	{"runner.file", func(c *Config) any { return &c.Runner.File }},
+	{"runner.spool_dir", func(c *Config) any { return &c.Runner.SpoolDir }},
+	{"runner.insecure", func(c *Config) any { return &c.Runner.Insecure }},
+	{"cache", func(c *Config) any { return &c.Cache }},
+	{"container.docker_host", func(c *Config) any { return &c.Container.DockerHost }},
+	{"container.podman_host", func(c *Config) any { return &c.Container.PodmanHost }},
+	{"container.reaper", func(c *Config) any { return &c.Container.Reaper }},
+	{"metrics", func(c *Config) any { return &c.Metrics }},
+	{"health.enabled", func(c *Config) any { return &c.Health.Enabled }},
+	{"health.addr", func(c *Config) any { return &c.Health.Addr }},
+	{"admin", func(c *Config) any { return &c.Admin }},
+}
+
+// RestartRequired returns the names of the fields which have been changed from prev to next,
+// but can't be applied without restarting the daemon.
+func RestartRequired(prev, next *Config) []string {
+	var names []string
+	for _, f := range restartRequired {
+		if !reflect.DeepEqual(f.field(prev), f.field(next)) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// KeepRestartRequired copies the fields which can't be changed without restarting the daemon from running to next,
+// so next can be applied to the running daemon.
+func KeepRestartRequired(running, next *Config) {
+	for _, f := range restartRequired {
+		reflect.ValueOf(f.field(next)).Elem().Set(reflect.ValueOf(f.field(running)).Elem())
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartRequired(t *testing.T) {
+	prev := &Config{
+		Runner:    Runner{File: ".runner", Capacity: 1, FetchInterval: time.Second},
+		Container: Container{DockerHost: "unix:///var/run/docker.sock"},
+		Metrics:   Metrics{Enabled: true, Addr: ":9101"},
+		Health:    Health{Enabled: true, Addr: ":9102", FetchIntervals: 3},
+	}
+
+	next := *prev
+	next.Runner.Capacity = 4
+	next.Runner.FetchInterval = 2 * time.Second
+	next.Health.FetchIntervals = 5
+	assert.Empty(t, RestartRequired(prev, &next), "these fields are applied to the running daemon")
+
+	next.Runner.File = ".runner2"
+	next.Container.DockerHost = "-"
+	next.Metrics.Addr = ":9200"
+	next.Container.Reaper.Interval = time.Hour
+	next.Health.Addr = ":9201"
+	assert.Equal(t, []string{"runner.file", "container.docker_host", "container.reaper", "metrics", "health.addr"}, RestartRequired(prev, &next))
+}
+
+func TestKeepRestartRequired(t *testing.T) {
+	running := &Config{
+		Runner: Runner{File: ".runner", Capacity: 1},
+		Container: Container{
+			DockerHost: "unix:///run/user/1000/docker.sock", // adjusted by the daemon
+			Reaper:     Reaper{GracePeriod: 10 * time.Minute},
+		},
+		Admin: Admin{Enabled: true, Addr: "127.0.0.1:9103"},
+	}
+	next := &Config{
+		Runner:    Runner{File: ".runner2", Capacity: 4},
+		Container: Container{DockerHost: "", Reaper: Reaper{GracePeriod: time.Minute}},
+		Admin:     Admin{Enabled: false},
+	}
+
+	KeepRestartRequired(running, next)
+	assert.Equal(t, ".runner", next.Runner.File)
+	assert.Equal(t, "unix:///run/user/1000/docker.sock", next.Container.DockerHost)
+	assert.Equal(t, Admin{Enabled: true, Addr: "127.0.0.1:9103"}, next.Admin)
+	assert.Equal(t, 10*time.Minute, next.Container.Reaper.GracePeriod)
+	assert.Equal(t, 4, next.Runner.Capacity, "other fields are kept from next")
+	assert.Empty(t, RestartRequired(running, next))
+}
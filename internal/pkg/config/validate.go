@@ -0,0 +1,502 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"gitea.com/gitea/act_runner/internal/pkg/imagepolicy"
+	"gitea.com/gitea/act_runner/internal/pkg/labels"
+)
+
+// ValidationError is a problem found in a configuration file.
+type ValidationError struct {
+	Line    int    // Line is the line of the problem in the file, 0 if unknown.
+	Column  int    // Column is the column of the problem in the file, 0 if unknown.
+	Field   string // Field is the path of the field, like "runner.fetch_interval".
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Message
+	if e.Field != "" {
+		msg = e.Field + ": " + msg
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, msg)
+	}
+	return msg
+}
+
+// ValidationErrors are all the problems found in a configuration file.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, v := range e {
+		msgs = append(msgs, v.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LoadStrict is like LoadDefault, but it returns ValidationErrors if the file doesn't pass Validate.
+func LoadStrict(file string) (*Config, error) {
+	if file != "" {
+		if err := Validate(file); err != nil {
+			return nil, err
+		}
+	}
+	return LoadDefault(file)
+}
+
+// Validate checks the configuration file strictly.
+// Unlike LoadDefault, it rejects unknown fields, invalid labels, durations out of range and unusable paths.
+// The returned error is ValidationErrors if the file can be parsed as YAML.
+func Validate(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("open config file %q: %w", file, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("parse config file %q: %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		// an empty file is a valid configuration
+		return nil
+	}
+	root := doc.Content[0]
+
+	v := &validator{}
+	v.checkNode(root, reflect.TypeOf(Config{}), "")
+	if len(v.errs) > 0 {
+		// the semantic checks make no sense if the structure is wrong
+		return v.errs
+	}
+
+	cfg := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("parse config file %q: %w", file, err)
+	}
+	v.checkValues(root, cfg)
+	if len(v.errs) > 0 {
+		sort.SliceStable(v.errs, func(i, j int) bool {
+			return v.errs[i].Line < v.errs[j].Line
+		})
+		return v.errs
+	}
+	return nil
+}
+
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(node *yaml.Node, field, format string, a ...any) {
+	e := &ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(format, a...),
+	}
+	if node != nil {
+		e.Line = node.Line
+		e.Column = node.Column
+	}
+	v.errs = append(v.errs, e)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// checkNode checks that node can be decoded into a value of type t, without unknown fields.
+func (v *validator) checkNode(node *yaml.Node, t reflect.Type, field string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.add(node, field, "expected a mapping")
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			sf, ok := fieldByTag(t, key.Value)
+			if !ok {
+				v.add(key, joinField(field, key.Value), "unknown field")
+				continue
+			}
+			v.checkNode(value, sf.Type, joinField(field, key.Value))
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			v.checkNode(value, t.Elem(), joinField(field, key.Value))
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			v.checkNode(item, t.Elem(), field+"["+strconv.Itoa(i)+"]")
+		}
+	default:
+		if t == durationType && node.Kind == yaml.ScalarNode {
+			// yaml.v3 accepts integers as nanoseconds, which is never intended
+			if _, err := time.ParseDuration(node.Value); err != nil {
+				v.add(node, field, "invalid duration %q, use a number with a unit like \"30s\" or \"3h\"", node.Value)
+			}
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			var typeErr *yaml.TypeError
+			if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+				v.add(node, field, "%s", strings.TrimPrefix(typeErr.Errors[0], fmt.Sprintf("line %d: ", node.Line)))
+			} else {
+				v.add(node, field, "%v", err)
+			}
+		}
+	}
+}
+
+// checkValues checks the values of cfg decoded from root.
+func (v *validator) checkValues(root *yaml.Node, cfg *Config) {
+	at := func(field string) *yaml.Node {
+		return lookupNode(root, field)
+	}
+
+	if cfg.Log.Level != "" {
+		if _, err := log.ParseLevel(cfg.Log.Level); err != nil {
+			v.add(at("log.level"), "log.level", "%v", err)
+		}
+	}
+
+	if cfg.Runner.Capacity < 0 {
+		v.add(at("runner.capacity"), "runner.capacity", "must not be negative")
+	}
+	var ls labels.Labels
+	for i, l := range cfg.Runner.Labels {
+		field := fmt.Sprintf("runner.labels[%d]", i)
+		label, err := labels.Parse(l)
+		if err != nil {
+			v.add(at(field), field, "invalid label %q: %v", l, err)
+			continue
+		}
+		ls = append(ls, label)
+	}
+	var sets labels.Sets
+	for i, set := range cfg.Runner.LabelSets {
+		field := fmt.Sprintf("runner.label_sets[%d]", i)
+		parsed, err := labels.ParseSet(set.Labels, set.Platform)
+		if err != nil {
+			v.add(at(field), field, "invalid label set: %v", err)
+			continue
+		}
+		sets = append(sets, parsed)
+		if len(cfg.Runner.Labels) == 0 {
+			// the labels are in the registration file
+			continue
+		}
+		for _, name := range set.Labels {
+			if !slices.ContainsFunc(cfg.Runner.Labels, func(l string) bool {
+				return strings.SplitN(l, ":", 2)[0] == name
+			}) {
+				v.add(at(field+".labels"), field+".labels", "label %q is not in runner.labels", name)
+			}
+		}
+	}
+	if (ls.RequireDocker() || sets.RequireDocker()) && (ls.RequirePodman() || sets.RequirePodman()) {
+		v.add(at("runner.labels"), "runner.labels", "docker and podman labels can't be used by the same runner")
+	}
+	if (ls.Require(labels.SchemeSSH) || sets.Require(labels.SchemeSSH)) && cfg.SSH.KeyFile == "" && len(cfg.SSH.HostKeys) == 0 {
+		v.add(at("runner.labels"), "ssh.key_file", "required by ssh labels")
+	}
+	switch cfg.Runner.NoMatch {
+	case "", NoMatchFail, NoMatchLegacy:
+	case NoMatchDefault:
+		if cfg.Runner.DefaultLabel == "" {
+			v.add(at("runner.no_match"), "runner.default_label", "required if runner.no_match is %q", NoMatchDefault)
+		} else if len(cfg.Runner.Labels) > 0 && !slices.ContainsFunc(cfg.Runner.Labels, func(l string) bool {
+			return strings.SplitN(l, ":", 2)[0] == cfg.Runner.DefaultLabel
+		}) {
+			v.add(at("runner.default_label"), "runner.default_label", "label %q is not in runner.labels", cfg.Runner.DefaultLabel)
+		}
+	default:
+		v.add(at("runner.no_match"), "runner.no_match", "must be one of %q, %q or %q", NoMatchFail, NoMatchDefault, NoMatchLegacy)
+	}
+	v.checkLimits(at, "container.limits", cfg.Container.Limits)
+	v.checkImagePolicy(at, "container.images", cfg.Container.Images)
+	v.checkEgressPolicy(at, "container", cfg.Container)
+	for _, name := range slices.Sorted(maps.Keys(cfg.Container.Profiles)) {
+		if limits := cfg.Container.Profiles[name].Limits; limits != nil {
+			v.checkLimits(at, "container.profiles."+name+".limits", *limits)
+		}
+		if images := cfg.Container.Profiles[name].Images; images != nil {
+			v.checkImagePolicy(at, "container.profiles."+name+".images", *images)
+		}
+		if profile := cfg.Container.Profiles[name]; profile.Egress != nil || profile.Network != nil {
+			v.checkEgressPolicy(at, "container.profiles."+name, cfg.Container.ForLabel(name))
+		}
+	}
+	if len(cfg.Runner.Labels) > 0 {
+		for _, name := range slices.Sorted(maps.Keys(cfg.Container.Profiles)) {
+			if ls.Get(name) == nil && !slices.ContainsFunc(sets, func(set *labels.Set) bool {
+				return set.Label.Name == name
+			}) {
+				v.add(at("container.profiles."+name), "container.profiles."+name, "label %q is not in runner.labels or runner.label_sets", name)
+			}
+		}
+	}
+	for _, d := range []struct {
+		field string
+		value time.Duration
+		min   time.Duration
+	}{
+		{"runner.timeout", cfg.Runner.Timeout, time.Second},
+		{"runner.shutdown_timeout", cfg.Runner.ShutdownTimeout, 0},
+		{"runner.fetch_timeout", cfg.Runner.FetchTimeout, time.Second},
+		{"runner.fetch_interval", cfg.Runner.FetchInterval, time.Second},
+		{"runner.fetch_backoff_max", cfg.Runner.FetchBackoffMax, time.Second},
+		{"lxc.start_timeout", cfg.LXC.StartTimeout, time.Second},
+		{"ssh.connect_timeout", cfg.SSH.ConnectTimeout, time.Second},
+		{"kubernetes.start_timeout", cfg.Kubernetes.StartTimeout, time.Second},
+		{"container.reaper.interval", cfg.Container.Reaper.Interval, time.Minute},
+		{"container.reaper.grace_period", cfg.Container.Reaper.GracePeriod, time.Minute},
+	} {
+		// zero means the default value
+		if d.value < 0 || (d.value > 0 && d.value < d.min) {
+			v.add(at(d.field), d.field, "%s is out of range, it must be at least %s", d.value, d.min)
+		}
+	}
+	if cfg.Runner.FetchBackoffMax > 0 && cfg.Runner.FetchBackoffMax < cfg.Runner.FetchInterval {
+		v.add(at("runner.fetch_backoff_max"), "runner.fetch_backoff_max", "must not be less than runner.fetch_interval")
+	}
+	if cfg.Runner.EnvFile != "" {
+		// a missing env file is ignored, but an existing one must be readable
+		if stat, err := os.Stat(cfg.Runner.EnvFile); err == nil {
+			if stat.IsDir() {
+				v.add(at("runner.env_file"), "runner.env_file", "%q is a directory", cfg.Runner.EnvFile)
+			} else if f, err := os.Open(cfg.Runner.EnvFile); err != nil {
+				v.add(at("runner.env_file"), "runner.env_file", "%v", err)
+			} else {
+				f.Close()
+			}
+		}
+	}
+
+	if cfg.Cache.Dir != "" {
+		if stat, err := os.Stat(cfg.Cache.Dir); err == nil {
+			if !stat.IsDir() {
+				v.add(at("cache.dir"), "cache.dir", "%q is not a directory", cfg.Cache.Dir)
+			} else if _, err := os.ReadDir(cfg.Cache.Dir); err != nil {
+				v.add(at("cache.dir"), "cache.dir", "%v", err)
+			}
+		}
+	}
+
+	if cfg.SSH.KeyFile != "" {
+		if _, err := os.Stat(cfg.SSH.KeyFile); err != nil {
+			v.add(at("ssh.key_file"), "ssh.key_file", "%v", err)
+		}
+	}
+	for _, host := range slices.Sorted(maps.Keys(cfg.SSH.HostKeys)) {
+		// host names contain dots, so the position is the one of the map
+		if _, err := os.Stat(cfg.SSH.HostKeys[host]); err != nil {
+			v.add(at("ssh.host_keys"), "ssh.host_keys."+host, "%v", err)
+		}
+	}
+
+	for _, f := range []struct {
+		field string
+		value string
+	}{
+		{"kubernetes.token_file", cfg.Kubernetes.TokenFile},
+		{"kubernetes.ca_file", cfg.Kubernetes.CAFile},
+		{"kubernetes.pod_template", cfg.Kubernetes.PodTemplate},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if _, err := os.Stat(f.value); err != nil {
+			v.add(at(f.field), f.field, "%v", err)
+		}
+	}
+
+	if cfg.Health.FetchIntervals < 0 {
+		v.add(at("health.fetch_intervals"), "health.fetch_intervals", "must not be negative")
+	}
+	for _, a := range []struct {
+		field string
+		value string
+	}{
+		{"metrics.addr", cfg.Metrics.Addr},
+		{"health.addr", cfg.Health.Addr},
+		{"admin.addr", cfg.Admin.Addr},
+	} {
+		if a.value == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(a.value); err != nil {
+			v.add(at(a.field), a.field, "invalid address %q: %v", a.value, err)
+		}
+	}
+}
+
+// checkLimits checks the container limits at field.
+func (v *validator) checkLimits(at func(string) *yaml.Node, field string, limits ContainerLimits) {
+	if limits.CPUs < 0 {
+		v.add(at(field+".cpus"), field+".cpus", "must not be negative")
+	}
+	if limits.Pids < 0 {
+		v.add(at(field+".pids"), field+".pids", "must not be negative")
+	}
+	for _, size := range []struct {
+		field string
+		value string
+	}{
+		{field + ".memory", limits.Memory},
+		{field + ".disk_size", limits.DiskSize},
+	} {
+		if size.value == "" {
+			continue
+		}
+		if _, err := units.RAMInBytes(size.value); err != nil {
+			v.add(at(size.field), size.field, "%v", err)
+		}
+	}
+	for i, ulimit := range limits.Ulimits {
+		f := fmt.Sprintf("%s.ulimits[%d]", field, i)
+		if _, err := units.ParseUlimit(ulimit); err != nil {
+			v.add(at(f), f, "%v", err)
+		}
+	}
+	for i, tmpfs := range limits.Tmpfs {
+		f := fmt.Sprintf("%s.tmpfs[%d]", field, i)
+		if path, _, _ := strings.Cut(tmpfs, ":"); !strings.HasPrefix(path, "/") {
+			v.add(at(f), f, "%q must start with an absolute path", tmpfs)
+		}
+	}
+}
+
+// checkImagePolicy checks the patterns of the image policy at field.
+func (v *validator) checkImagePolicy(at func(string) *yaml.Node, field string, policy ImagePolicy) {
+	for _, list := range []struct {
+		field    string
+		patterns []string
+	}{
+		{field + ".allow", policy.Allow},
+		{field + ".deny", policy.Deny},
+	} {
+		for i, pattern := range list.patterns {
+			f := fmt.Sprintf("%s[%d]", list.field, i)
+			if _, err := imagepolicy.Compile(pattern); err != nil {
+				v.add(at(f), f, "invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+}
+
+// checkEgressPolicy checks the egress policy of the container configuration at field.
+func (v *validator) checkEgressPolicy(at func(string) *yaml.Node, field string, c Container) {
+	if !c.Egress.Enabled {
+		return
+	}
+	if c.Network != "" {
+		v.add(at(field+".egress.enabled"), field+".network", "must be empty if the egress policy is enabled, the runner creates the networks")
+	}
+	for i, volume := range c.ValidVolumes {
+		// the docker host isn't mounted to the containers, but it mustn't be mounted as a volume either
+		if base := path.Base(strings.TrimPrefix(volume, "unix://")); base == "docker.sock" || base == "podman.sock" {
+			f := fmt.Sprintf("%s.valid_volumes[%d]", field, i)
+			v.add(at(f), f, "%q can't be mounted if the egress policy is enabled, the containers started with it wouldn't be restricted", volume)
+		}
+	}
+	for i, allow := range c.Egress.Allow {
+		f := fmt.Sprintf("%s.egress.allow[%d]", field, i)
+		if _, err := netip.ParsePrefix(allow); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(allow); err == nil {
+			continue
+		}
+		if strings.ContainsAny(allow, "/: ") {
+			v.add(at(f), f, "%q is neither a CIDR, an IP address nor a host name", allow)
+		}
+	}
+}
+
+// lookupNode returns the node of field like "runner.labels[1]" in root, or nil if it doesn't exist.
+func lookupNode(root *yaml.Node, field string) *yaml.Node {
+	node := root
+	for _, part := range strings.Split(field, ".") {
+		index := -1
+		if i := strings.IndexByte(part, '['); i >= 0 && strings.HasSuffix(part, "]") {
+			index, _ = strconv.Atoi(part[i+1 : len(part)-1])
+			part = part[:i]
+		}
+
+		var next *yaml.Node
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == part {
+					next = node.Content[i+1]
+				}
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		if index >= 0 {
+			if next.Kind != yaml.SequenceNode || index >= len(next.Content) {
+				return nil
+			}
+			next = next.Content[index]
+		}
+		node = next
+	}
+	return node
+}
+
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if tag == "" {
+			tag = strings.ToLower(f.Name)
+		}
+		if tag == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,220 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "example",
+			content: string(Example),
+		},
+		{
+			name:    "empty",
+			content: "",
+		},
+		{
+			name: "unknown fields",
+			content: `
+runner:
+  fetch_intervall: 2s
+contianer:
+  network: host
+`,
+			want: []string{
+				"line 3, column 3: runner.fetch_intervall: unknown field",
+				"line 4, column 1: contianer: unknown field",
+			},
+		},
+		{
+			name: "wrong types",
+			content: `
+runner:
+  capacity: many
+  timeout: 300
+`,
+			want: []string{
+				"line 3, column 13: runner.capacity: cannot unmarshal !!str `many` into int",
+				`line 4, column 12: runner.timeout: invalid duration "300", use a number with a unit like "30s" or "3h"`,
+			},
+		},
+		{
+			name: "invalid values",
+			content: `
+log:
+  level: verbose
+runner:
+  fetch_interval: 10ms
+  labels:
+    - ubuntu-latest:docker://node:18
+    - ubuntu-18.04:vm:ubuntu-18.04
+metrics:
+  addr: localhost
+`,
+			want: []string{
+				`line 3, column 10: log.level: not a valid logrus Level: "verbose"`,
+				"line 5, column 19: runner.fetch_interval: 10ms is out of range, it must be at least 1s",
+				`line 8, column 7: runner.labels[1]: invalid label "ubuntu-18.04:vm:ubuntu-18.04": unsupported schema: vm`,
+				`line 10, column 9: metrics.addr: invalid address "localhost": address localhost: missing port in address`,
+			},
+		},
+		{
+			name: "docker and podman",
+			content: `
+runner:
+  labels:
+    - ubuntu-latest:docker://node:18
+    - fedora:podman://fedora:40
+`,
+			want: []string{
+				"line 4, column 5: runner.labels: docker and podman labels can't be used by the same runner",
+			},
+		},
+		{
+			name: "no match",
+			content: `
+runner:
+  labels:
+    - ubuntu-latest:docker://node:18
+  no_match: default
+  default_label: macos-latest
+`,
+			want: []string{
+				`line 6, column 18: runner.default_label: label "macos-latest" is not in runner.labels`,
+			},
+		},
+		{
+			name: "container profiles",
+			content: `
+runner:
+  labels:
+    - build-large:docker://node:18
+    - gpu:docker://node:18
+    - untrusted:docker://node:18
+  label_sets:
+    - labels: [gpu, build-large]
+      platform: docker://nvidia/cuda:12.4.1-base-ubuntu22.04
+container:
+  profiles:
+    build-large:
+      options: --cpus 8 --memory 16g
+    gpu+build-large:
+      privileged: true
+    untrusted:
+      network: none
+      valid_volumes: []
+    macos-latest:
+      privileged: false
+`,
+			want: []string{
+				`line 20, column 7: container.profiles.macos-latest: label "macos-latest" is not in runner.labels or runner.label_sets`,
+			},
+		},
+		{
+			name: "container limits",
+			content: `
+container:
+  limits:
+    cpus: -1
+    memory: 4 gigabytes
+    ulimits:
+      - nofile=1024:2048
+      - nofile
+    tmpfs:
+      - tmp:size=1g
+  profiles:
+    build-large:
+      limits:
+        disk_size: 20x
+`,
+			want: []string{
+				`line 4, column 11: container.limits.cpus: must not be negative`,
+				`line 5, column 13: container.limits.memory: invalid suffix: 'gigabytes'`,
+				`line 8, column 9: container.limits.ulimits[1]: invalid ulimit argument: nofile`,
+				`line 10, column 9: container.limits.tmpfs[0]: "tmp:size=1g" must start with an absolute path`,
+				`line 14, column 20: container.profiles.build-large.limits.disk_size: invalid suffix: 'x'`,
+			},
+		},
+		{
+			name: "image policy",
+			content: `
+container:
+  images:
+    allow:
+      - docker.io/library/*
+      - "regex:ghcr.io/(gitea"
+  profiles:
+    untrusted:
+      images:
+        deny:
+          - "node:[14"
+`,
+			want: []string{
+				"line 6, column 9: container.images.allow[1]: invalid pattern \"regex:ghcr.io/(gitea\": error parsing regexp: missing closing ): `^(?:ghcr.io/(gitea)$`",
+				`line 11, column 13: container.profiles.untrusted.images.deny[0]: invalid pattern "node:[14": unexpected end of input`,
+			},
+		},
+		{
+			name: "egress policy",
+			content: `
+container:
+  network: ci
+  profiles:
+    untrusted:
+      network: ""
+      egress:
+        enabled: true
+        allow:
+          - 10.0.0.0/8
+          - proxy.example.com
+          - https://proxy.example.com
+    trusted:
+      egress:
+        enabled: true
+    docker:
+      network: ""
+      valid_volumes:
+        - /var/run/docker.sock
+      egress:
+        enabled: true
+`,
+			want: []string{
+				`line 12, column 13: container.profiles.untrusted.egress.allow[2]: "https://proxy.example.com" is neither a CIDR, an IP address nor a host name`,
+				`line 15, column 18: container.profiles.trusted.network: must be empty if the egress policy is enabled, the runner creates the networks`,
+				`line 19, column 11: container.profiles.docker.valid_volumes[0]: "/var/run/docker.sock" can't be mounted if the egress policy is enabled, the containers started with it wouldn't be restricted`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(file, []byte(tt.content), 0o600))
+
+			err := Validate(file)
+			if len(tt.want) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var errs ValidationErrors
+			require.ErrorAs(t, err, &errs)
+			got := make([]string, 0, len(errs))
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
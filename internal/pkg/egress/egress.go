@@ -0,0 +1,215 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package egress restricts the destinations which the containers of a job can connect to.
+// The containers run on a bridge network created for the job, and an iptables chain hooked into DOCKER-USER
+// rejects the connections from the bridge to the destinations which aren't allowed.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+const (
+	// DefaultIptables is the iptables command used if none is configured.
+	DefaultIptables = "iptables"
+	// Label is the label of the networks whose egress is restricted, so the reaper knows their chains have to be removed.
+	Label = "com.gitea.act-runner.egress"
+)
+
+// ResolvConfs are the files of the nameservers which docker forwards the queries of the containers to.
+// Docker uses the one of systemd-resolved instead of its stub resolver on the loopback.
+var ResolvConfs = []string{"/etc/resolv.conf", "/run/systemd/resolve/resolv.conf"}
+
+// Nameservers returns the IPv4 nameservers in files, the missing files are skipped.
+// The nameservers on the loopback are skipped, the queries to them don't leave the host through the network of the job.
+func Nameservers(files ...string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "nameserver" {
+				continue
+			}
+			addr, err := netip.ParseAddr(fields[1])
+			if err != nil || !addr.Is4() || addr.IsLoopback() || slices.Contains(addrs, addr) {
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// Resolve returns the prefixes of allow, whose entries are CIDRs, IP addresses or host names.
+// Host names are resolved to their IPv4 addresses once, so the later changes of their records aren't followed.
+func Resolve(ctx context.Context, allow []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range allow {
+		if prefix, err := netip.ParsePrefix(v); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(v); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", v)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve %q: %w", v, err)
+		}
+		for _, addr := range addrs {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return prefixes, nil
+}
+
+// Network is a bridge network whose egress is restricted.
+type Network struct {
+	Name string
+
+	cli      *client.Client
+	id       string
+	iptables string
+	bridge   string
+	hook     []string // hook is the rule of DOCKER-USER jumping to the chain, without the action
+	chain    string
+}
+
+func newNetwork(iptables, id string) *Network {
+	// the name of the bridge is chosen by docker from the id of the network
+	bridge := "br-" + id[:min(len(id), 12)]
+	return &Network{
+		id:       id,
+		iptables: iptables,
+		bridge:   bridge,
+		hook:     []string{"DOCKER-USER", "-i", bridge, "-j"},
+		chain:    "EGRESS-" + bridge,
+	}
+}
+
+// Create creates the network with name and labels, and the iptables chain allowing only the connections to allowed,
+// and the DNS queries to the nameservers of this host, which the embedded resolver of docker forwards from the network.
+// The docker daemon is the one of DOCKER_HOST, and it has to run on this host for the chain to apply.
+func Create(ctx context.Context, iptables, name string, labels map[string]string, allowed []netip.Prefix) (_ *Network, retErr error) {
+	if iptables == "" {
+		iptables = DefaultIptables
+	}
+	nameservers, err := Nameservers(ResolvConfs...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the nameservers: %w", err)
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	labels = maps.Clone(labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	// the chain is left behind with the network if the runner is killed, the reaper removes it with the label
+	labels[Label] = "true"
+	resp, err := cli.NetworkCreate(ctx, name, types.NetworkCreate{Driver: "bridge", Labels: labels})
+	if err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("cannot create network %s: %w", name, err)
+	}
+	n := newNetwork(iptables, resp.ID)
+	n.Name = name
+	n.cli = cli
+	defer func() {
+		if retErr != nil {
+			_ = n.Remove(context.WithoutCancel(ctx))
+		}
+	}()
+
+	for _, rule := range Rules(n.chain, n.bridge, allowed, nameservers) {
+		if err := n.run(ctx, rule...); err != nil {
+			return nil, err
+		}
+	}
+	if err := n.run(ctx, append([]string{"-I"}, append(n.hook, n.chain)...)...); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Rules returns the arguments of iptables which create chain for the connections from bridge.
+// The connections to allowed, the DNS queries to nameservers, the connections to the containers on the same bridge
+// and the replies of the established connections are allowed, the others are rejected.
+func Rules(chain, bridge string, allowed []netip.Prefix, nameservers []netip.Addr) [][]string {
+	rules := [][]string{
+		{"-N", chain},
+		{"-A", chain, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "RETURN"},
+		{"-A", chain, "-o", bridge, "-j", "RETURN"},
+	}
+	for _, addr := range nameservers {
+		for _, proto := range []string{"udp", "tcp"} {
+			rules = append(rules, []string{"-A", chain, "-d", addr.String() + "/32", "-p", proto, "--dport", "53", "-j", "RETURN"})
+		}
+	}
+	for _, prefix := range allowed {
+		if !prefix.Addr().Is4() {
+			// the network is IPv4 only
+			continue
+		}
+		rules = append(rules, []string{"-A", chain, "-d", prefix.String(), "-j", "RETURN"})
+	}
+	return append(rules, []string{"-A", chain, "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"})
+}
+
+// Remove removes the iptables chain and the network, it's fine to call it on a network partly created.
+func (n *Network) Remove(ctx context.Context) error {
+	var errs []error
+	// the rules may not exist if the creation failed halfway, only the removal of the network is required
+	_ = n.removeRules(ctx)
+	if err := n.cli.NetworkRemove(ctx, n.id); err != nil {
+		errs = append(errs, fmt.Errorf("cannot remove network %s: %w", n.Name, err))
+	}
+	errs = append(errs, n.cli.Close())
+	return errors.Join(errs...)
+}
+
+// RemoveRules removes the iptables chain of the network with id and the rule jumping to it,
+// which are left behind with the network if the runner is killed.
+func RemoveRules(ctx context.Context, iptables, id string) error {
+	return newNetwork(iptables, id).removeRules(ctx)
+}
+
+func (n *Network) removeRules(ctx context.Context) error {
+	return errors.Join(
+		n.run(ctx, append([]string{"-D"}, append(n.hook, n.chain)...)...),
+		n.run(ctx, "-F", n.chain),
+		n.run(ctx, "-X", n.chain),
+	)
+}
+
+func (n *Network) run(ctx context.Context, args ...string) error {
+	// wait for the lock of xtables, other jobs may change the rules at the same time
+	cmd := exec.CommandContext(ctx, n.iptables, append([]string{"-w"}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w: %s", n.iptables, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
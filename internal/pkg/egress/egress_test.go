@@ -0,0 +1,66 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package egress
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	prefixes, err := Resolve(context.Background(), []string{"10.1.2.3/8", "192.168.1.10", "::1", "localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.10/32"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("127.0.0.1/32"),
+	}, prefixes)
+
+	_, err = Resolve(context.Background(), []string{"no-such-host.invalid"})
+	assert.ErrorContains(t, err, `cannot resolve "no-such-host.invalid"`)
+}
+
+func TestRules(t *testing.T) {
+	assert.Equal(t, [][]string{
+		{"-N", "EGRESS-br-0123456789ab"},
+		{"-A", "EGRESS-br-0123456789ab", "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "RETURN"},
+		{"-A", "EGRESS-br-0123456789ab", "-o", "br-0123456789ab", "-j", "RETURN"},
+		{"-A", "EGRESS-br-0123456789ab", "-d", "10.0.0.0/8", "-j", "RETURN"},
+		{"-A", "EGRESS-br-0123456789ab", "-d", "203.0.113.7/32", "-j", "RETURN"},
+		{"-A", "EGRESS-br-0123456789ab", "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"},
+	}, Rules("EGRESS-br-0123456789ab", "br-0123456789ab", []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("203.0.113.7/32"),
+	}, nil))
+}
+
+func TestRules_DNS(t *testing.T) {
+	rules := Rules("EGRESS-br-0123456789ab", "br-0123456789ab", nil, []netip.Addr{netip.MustParseAddr("192.0.2.53")})
+	// the queries are allowed before the other connections are rejected
+	assert.Equal(t, [][]string{
+		{"-A", "EGRESS-br-0123456789ab", "-d", "192.0.2.53/32", "-p", "udp", "--dport", "53", "-j", "RETURN"},
+		{"-A", "EGRESS-br-0123456789ab", "-d", "192.0.2.53/32", "-p", "tcp", "--dport", "53", "-j", "RETURN"},
+		{"-A", "EGRESS-br-0123456789ab", "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"},
+	}, rules[3:])
+}
+
+func TestNameservers(t *testing.T) {
+	dir := t.TempDir()
+	etc := filepath.Join(dir, "resolv.conf")
+	require.NoError(t, os.WriteFile(etc, []byte("# generated\nnameserver 127.0.0.53\noptions edns0\nsearch example.com\n"), 0o644))
+	resolved := filepath.Join(dir, "resolved.conf")
+	require.NoError(t, os.WriteFile(resolved, []byte("nameserver 192.0.2.53\nnameserver 2001:db8::53\nnameserver 198.51.100.53\nnameserver 192.0.2.53\n"), 0o644))
+
+	addrs, err := Nameservers(etc, resolved, filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.53"), netip.MustParseAddr("198.51.100.53")}, addrs)
+}
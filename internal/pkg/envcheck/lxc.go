@@ -0,0 +1,24 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package envcheck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CheckIfLXCRunning checks that command, the incus or lxc client, can reach its daemon.
+func CheckIfLXCRunning(ctx context.Context, command string) error {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, "info")
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cannot reach the daemon with %q, is it running? %w: %s", command, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package envcheck
+
+import (
+	"context"
+
+	"gitea.com/gitea/act_runner/internal/pkg/podman"
+)
+
+// CheckIfPodmanRunning checks that podmanHost is the API socket of a running Podman service.
+// Unlike CheckIfDockerRunning, it fails if podmanHost is a Docker daemon.
+func CheckIfPodmanRunning(ctx context.Context, podmanHost string) error {
+	cli, err := podman.NewClient(podmanHost)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	return cli.Ping(ctx)
+}
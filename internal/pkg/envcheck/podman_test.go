@@ -0,0 +1,32 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package envcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIfPodmanRunning(t *testing.T) {
+	podman := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/libpod/_ping" {
+			w.Header().Set("Libpod-API-Version", "4.9.3")
+			_, _ = w.Write([]byte("OK"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer podman.Close()
+	assert.NoError(t, CheckIfPodmanRunning(context.Background(), "tcp://"+strings.TrimPrefix(podman.URL, "http://")))
+
+	// a docker daemon doesn't know the libpod API
+	docker := httptest.NewServer(http.NotFoundHandler())
+	defer docker.Close()
+	assert.ErrorContains(t, CheckIfPodmanRunning(context.Background(), "tcp://"+strings.TrimPrefix(docker.URL, "http://")), "is not a podman service")
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package health provides the liveness and readiness checks of the runner daemon.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"gitea.com/gitea/act_runner/internal/pkg/envcheck"
+)
+
+// Poller is the part of the poller which the readiness check depends on.
+type Poller interface {
+	// LastFetch returns the time of the last successful FetchTask request.
+	LastFetch() time.Time
+	// Busy reports whether all the capacity of the runner is in use, so no task is being fetched.
+	Busy() bool
+	// Draining reports whether the runner has been asked to stop fetching tasks.
+	Draining() bool
+}
+
+// Checker checks the liveness and readiness of the runner daemon.
+type Checker struct {
+	poller      Poller
+	maxFetchAge atomic.Int64
+	dockerHost  string
+	declared    atomic.Bool
+}
+
+// NewChecker returns a Checker.
+// The runner is considered not ready if the last successful fetch is older than maxFetchAge while it has free capacity.
+// If dockerHost is not empty, the Docker daemon behind it must answer a ping for the runner to be alive.
+func NewChecker(poller Poller, maxFetchAge time.Duration, dockerHost string) *Checker {
+	c := &Checker{
+		poller:     poller,
+		dockerHost: dockerHost,
+	}
+	c.SetMaxFetchAge(maxFetchAge)
+	return c
+}
+
+// SetMaxFetchAge changes how old the last successful fetch may be for the runner to be ready.
+func (c *Checker) SetMaxFetchAge(maxFetchAge time.Duration) {
+	c.maxFetchAge.Store(int64(maxFetchAge))
+}
+
+// SetDeclared records whether the labels of the runner have been declared successfully.
+func (c *Checker) SetDeclared(declared bool) {
+	c.declared.Store(declared)
+}
+
+// Live returns an error if the runner is not able to run jobs anymore and should be restarted.
+func (c *Checker) Live(ctx context.Context) error {
+	if c.dockerHost == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return envcheck.CheckIfDockerRunning(ctx, c.dockerHost)
+}
+
+// Ready returns an error if the runner is not ready to pick up jobs.
+func (c *Checker) Ready(ctx context.Context) error {
+	if !c.declared.Load() {
+		return errors.New("runner has not been declared")
+	}
+	if c.poller.Draining() {
+		return errors.New("runner is draining")
+	}
+	if !c.poller.Busy() {
+		last := c.poller.LastFetch()
+		if last.IsZero() {
+			return errors.New("no task has been fetched successfully yet")
+		}
+		if age := time.Since(last); age > time.Duration(c.maxFetchAge.Load()) {
+			return fmt.Errorf("last successful fetch was %s ago", age.Truncate(time.Second))
+		}
+	}
+	return c.Live(ctx)
+}
+
+// LiveHandler returns the HTTP handler of the liveness endpoint.
+func (c *Checker) LiveHandler() http.Handler {
+	return checkHandler(c.Live)
+}
+
+// ReadyHandler returns the HTTP handler of the readiness endpoint.
+func (c *Checker) ReadyHandler() http.Handler {
+	return checkHandler(c.Ready)
+}
+
+func checkHandler(check func(ctx context.Context) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(r.Context()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintln(w, err.Error())
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
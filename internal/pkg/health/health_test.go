@@ -0,0 +1,141 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePoller struct {
+	lastFetch time.Time
+	busy      bool
+	draining  bool
+}
+
+func (p *fakePoller) LastFetch() time.Time { return p.lastFetch }
+func (p *fakePoller) Busy() bool           { return p.busy }
+func (p *fakePoller) Draining() bool       { return p.draining }
+
+// newDockerServer returns the host of a fake Docker daemon answering pings.
+func newDockerServer(t *testing.T) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_ping") {
+			w.Header().Set("Api-Version", "1.41")
+			_, _ = w.Write([]byte("OK"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(srv.Close)
+	return "tcp://" + strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestChecker_Ready(t *testing.T) {
+	stale := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name     string
+		poller   *fakePoller
+		declared bool
+		wantErr  string
+	}{
+		{
+			name:    "not declared",
+			poller:  &fakePoller{lastFetch: time.Now()},
+			wantErr: "runner has not been declared",
+		},
+		{
+			name:     "draining",
+			poller:   &fakePoller{lastFetch: time.Now(), draining: true},
+			declared: true,
+			wantErr:  "runner is draining",
+		},
+		{
+			name:     "never fetched",
+			poller:   &fakePoller{},
+			declared: true,
+			wantErr:  "no task has been fetched successfully yet",
+		},
+		{
+			name:     "stale last fetch while idle",
+			poller:   &fakePoller{lastFetch: stale},
+			declared: true,
+			wantErr:  "last successful fetch was 1h0m0s ago",
+		},
+		{
+			name:     "stale last fetch while busy",
+			poller:   &fakePoller{lastFetch: stale, busy: true},
+			declared: true,
+		},
+		{
+			name:     "recent fetch",
+			poller:   &fakePoller{lastFetch: time.Now()},
+			declared: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(tt.poller, time.Minute, "")
+			c.SetDeclared(tt.declared)
+			err := c.Ready(context.Background())
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("max fetch age is changed", func(t *testing.T) {
+		c := NewChecker(&fakePoller{lastFetch: stale}, time.Minute, "")
+		c.SetDeclared(true)
+		require.Error(t, c.Ready(context.Background()))
+		c.SetMaxFetchAge(2 * time.Hour)
+		assert.NoError(t, c.Ready(context.Background()))
+	})
+}
+
+func TestChecker_Live(t *testing.T) {
+	poller := &fakePoller{lastFetch: time.Now()}
+
+	c := NewChecker(poller, time.Minute, "")
+	assert.NoError(t, c.Live(context.Background()), "the docker daemon isn't checked without a host")
+
+	c = NewChecker(poller, time.Minute, newDockerServer(t))
+	c.SetDeclared(true)
+	assert.NoError(t, c.Live(context.Background()))
+	assert.NoError(t, c.Ready(context.Background()))
+
+	c = NewChecker(poller, time.Minute, "unix://"+filepath.Join(t.TempDir(), "docker.sock"))
+	c.SetDeclared(true)
+	assert.ErrorContains(t, c.Live(context.Background()), "cannot ping the docker daemon")
+	assert.ErrorContains(t, c.Ready(context.Background()), "cannot ping the docker daemon", "a dead runner isn't ready")
+}
+
+func TestChecker_Handlers(t *testing.T) {
+	c := NewChecker(&fakePoller{lastFetch: time.Now()}, time.Minute, "")
+
+	rec := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "runner has not been declared\n", rec.Body.String())
+
+	c.SetDeclared(true)
+	rec = httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	c.LiveHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
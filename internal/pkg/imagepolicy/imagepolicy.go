@@ -0,0 +1,100 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package imagepolicy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/gobwas/glob"
+)
+
+// RegexPrefix is the prefix of the patterns which are regular expressions instead of globs.
+const RegexPrefix = "regex:"
+
+// Matcher reports whether an image name matches a pattern.
+type Matcher func(name string) bool
+
+// Compile compiles a glob like "docker.io/library/*" or "ghcr.io/org/**", or a regular expression prefixed by RegexPrefix.
+// A regular expression has to match the whole name.
+func Compile(pattern string) (Matcher, error) {
+	if expr, ok := strings.CutPrefix(pattern, RegexPrefix); ok {
+		re, err := regexp.Compile(`^(?:` + expr + `)$`)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	g, err := glob.Compile(pattern, '/')
+	if err != nil {
+		return nil, err
+	}
+	return g.Match, nil
+}
+
+// Policy decides whether an image can be used.
+type Policy struct {
+	allow         []Matcher
+	deny          []Matcher
+	requireDigest bool
+}
+
+// New returns the policy allowing the images which match any of allow, or all images if allow is empty,
+// except the ones which match any of deny. If requireDigest is true, the images have to be pinned by digest.
+func New(allow, deny []string, requireDigest bool) (*Policy, error) {
+	p := &Policy{requireDigest: requireDigest}
+	for _, pattern := range allow {
+		m, err := Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		p.allow = append(p.allow, m)
+	}
+	for _, pattern := range deny {
+		m, err := Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		p.deny = append(p.deny, m)
+	}
+	return p, nil
+}
+
+// Enabled reports whether the policy restricts any image.
+func (p *Policy) Enabled() bool {
+	return len(p.allow) > 0 || len(p.deny) > 0 || p.requireDigest
+}
+
+// Check returns an error describing why image can't be used, or nil if it can.
+func (p *Policy) Check(image string) error {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return fmt.Errorf("image %q is invalid: %w", image, err)
+	}
+	if _, ok := named.(reference.Canonical); !ok && p.requireDigest {
+		return fmt.Errorf("image %q isn't pinned by digest", image)
+	}
+	// the names as written and as normalized, like "node" and "docker.io/library/node:latest"
+	named = reference.TagNameOnly(named)
+	names := []string{image, reference.FamiliarString(named), named.String()}
+	match := func(matchers []Matcher) bool {
+		for _, m := range matchers {
+			for _, name := range names {
+				if m(name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if match(p.deny) {
+		return fmt.Errorf("image %q is denied", image)
+	}
+	if len(p.allow) > 0 && !match(p.allow) {
+		return fmt.Errorf("image %q isn't allowed", image)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package imagepolicy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	p, err := New(
+		[]string{"docker.io/library/*", "ghcr.io/gitea/**", `regex:registry\.example\.com/ci/[a-z]+:v[0-9]+`},
+		[]string{"node:14*", "*/*/alpine:edge"},
+		false,
+	)
+	require.NoError(t, err)
+	assert.True(t, p.Enabled())
+
+	for image, want := range map[string]string{
+		"node:18":                       "",
+		"node":                          "",
+		"docker.io/library/postgres:16": "",
+		"ghcr.io/gitea/runner-images/ubuntu:22.04": "",
+		"registry.example.com/ci/builder:v3":       "",
+		"node:14-alpine":                           `image "node:14-alpine" is denied`,
+		"alpine:edge":                              `image "alpine:edge" is denied`,
+		"ghcr.io/other/image:1":                    `image "ghcr.io/other/image:1" isn't allowed`,
+		"registry.example.com/ci/builder:latest":   `image "registry.example.com/ci/builder:latest" isn't allowed`,
+		"Invalid:Image":                            `image "Invalid:Image" is invalid`,
+	} {
+		err := p.Check(image)
+		if want == "" {
+			assert.NoError(t, err, image)
+		} else {
+			assert.ErrorContains(t, err, want, image)
+		}
+	}
+}
+
+func TestPolicy_RequireDigest(t *testing.T) {
+	p, err := New(nil, nil, true)
+	require.NoError(t, err)
+	assert.True(t, p.Enabled())
+
+	assert.NoError(t, p.Check("node@sha256:b3b1a6e0e0ad1f8a0a8b7b0b1b1d1e1f1a1b1c1d1e1f1a1b1c1d1e1f1a1b1c1d"))
+	assert.EqualError(t, p.Check("node:18"), `image "node:18" isn't pinned by digest`)
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(nil, nil, false)
+	require.NoError(t, err)
+	assert.False(t, p.Enabled())
+	assert.NoError(t, p.Check("anything:latest"))
+
+	_, err = New([]string{"regex:("}, nil, false)
+	assert.ErrorContains(t, err, `invalid pattern "regex:("`)
+	_, err = New(nil, []string{"node:[14"}, false)
+	assert.ErrorContains(t, err, `invalid pattern "node:[14"`)
+}
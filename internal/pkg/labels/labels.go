@@ -4,13 +4,20 @@
 package labels
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"slices"
 	"strings"
 )
 
 const (
 	SchemeHost   = "host"
 	SchemeDocker = "docker"
+	SchemePodman = "podman"
+	SchemeLXC    = "lxc"
+	SchemeSSH    = "ssh"
+	SchemeK8s    = "k8s"
 )
 
 type Label struct {
@@ -32,54 +39,119 @@ func Parse(str string) (*Label, error) {
 	if len(splits) >= 3 {
 		label.Arg = splits[2]
 	}
-	if label.Schema != SchemeHost && label.Schema != SchemeDocker {
+	switch label.Schema {
+	case SchemeHost, SchemeDocker, SchemePodman:
+	case SchemeLXC:
+		if strings.TrimPrefix(label.Arg, "//") == "" {
+			return nil, fmt.Errorf("%s label requires the instance to clone, like %s:lxc://ubuntu-template", label.Name, label.Name)
+		}
+	case SchemeK8s:
+		if strings.TrimPrefix(label.Arg, "//") == "" {
+			return nil, fmt.Errorf("%s label requires the image of the job, like %s:k8s://node:18", label.Name, label.Name)
+		}
+	case SchemeSSH:
+		if u, err := url.Parse("ssh:" + label.Arg); err != nil || u.User == nil || u.User.Username() == "" || u.Hostname() == "" {
+			return nil, fmt.Errorf("%s label requires the user and the host, like %s:ssh://user@host or %s:ssh://user@host:2222", label.Name, label.Name, label.Name)
+		}
+	default:
 		return nil, fmt.Errorf("unsupported schema: %s", label.Schema)
 	}
 	return label, nil
 }
 
+// Platform returns the platform of the label for act, an image or "-self-hosted" for the host.
+func (l *Label) Platform() string {
+	switch l.Schema {
+	case SchemeDocker, SchemePodman:
+		// "//" will be ignored
+		return strings.TrimPrefix(l.Arg, "//")
+	case SchemeHost:
+		return "-self-hosted"
+	case SchemeLXC, SchemeSSH, SchemeK8s:
+		// act doesn't see it, the job runs on the host of the sandbox
+		return l.Schema + ":" + l.Arg
+	default:
+		// It should not happen, because Parse has checked it.
+		return ""
+	}
+}
+
+// Sandboxed reports whether the jobs of the label run in a sandbox created for each task, see package sandbox.
+func (l *Label) Sandboxed() bool {
+	return l.Schema == SchemeLXC || l.Schema == SchemeSSH || l.Schema == SchemeK8s
+}
+
 type Labels []*Label
 
-func (l Labels) RequireDocker() bool {
+// Require reports whether any label has schema.
+func (l Labels) Require(schema string) bool {
 	for _, label := range l {
-		if label.Schema == SchemeDocker {
+		if label.Schema == schema {
 			return true
 		}
 	}
 	return false
 }
 
-func (l Labels) PickPlatform(runsOn []string) string {
-	platforms := make(map[string]string, len(l))
+func (l Labels) RequireDocker() bool {
+	return l.Require(SchemeDocker)
+}
+
+func (l Labels) RequirePodman() bool {
+	return l.Require(SchemePodman)
+}
+
+// ErrNoMatch is returned by Pick if the runner can't run a job.
+var ErrNoMatch = errors.New("no matching label")
+
+// Pick returns the label whose platform a job with runsOn runs on.
+// Every entry of runsOn must be one of the labels, otherwise ErrNoMatch is returned.
+// If sets are given, the set with the most labels that are all in runsOn is picked.
+// Without a matching set, all the labels in runsOn must have the same platform.
+func (l Labels) Pick(runsOn []string, sets ...*Set) (*Label, error) {
+	labels := make(map[string]*Label, len(l))
 	for _, label := range l {
-		switch label.Schema {
-		case SchemeDocker:
-			// "//" will be ignored
-			platforms[label.Name] = strings.TrimPrefix(label.Arg, "//")
-		case SchemeHost:
-			platforms[label.Name] = "-self-hosted"
-		default:
-			// It should not happen, because Parse has checked it.
-			continue
-		}
+		labels[label.Name] = label
 	}
+
+	var missing []string
 	for _, v := range runsOn {
-		if v, ok := platforms[v]; ok {
-			return v
+		if _, ok := labels[v]; !ok {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 || len(runsOn) == 0 {
+		return nil, fmt.Errorf("%w: the runner doesn't have labels %v of runs-on %v", ErrNoMatch, missing, runsOn)
+	}
+
+	var picked *Set
+	for _, set := range sets {
+		if set.Match(runsOn) && (picked == nil || len(set.Names) > len(picked.Names)) {
+			picked = set
 		}
 	}
+	if picked != nil {
+		return picked.Label, nil
+	}
 
-	// TODO: support multiple labels
-	// like:
-	//   ["ubuntu-22.04"] => "ubuntu:22.04"
-	//   ["with-gpu"] => "linux:with-gpu"
-	//   ["ubuntu-22.04", "with-gpu"] => "ubuntu:22.04_with-gpu"
+	label := labels[runsOn[0]]
+	for _, v := range runsOn[1:] {
+		if other := labels[v]; other.Platform() != label.Platform() {
+			return nil, fmt.Errorf("%w: labels %q and %q of runs-on %v select different platforms, a label set for them is required",
+				ErrNoMatch, label.Name, other.Name, runsOn)
+		}
+	}
+	return label, nil
+}
 
-	// return default.
-	// So the runner receives a task with a label that the runner doesn't have,
-	// it happens when the user have edited the label of the runner in the web UI.
-	// TODO: it may be not correct, what if the runner is used as host mode only?
-	return "gitea/runner-images:ubuntu-latest"
+// Get returns the label with name, or nil if there isn't.
+func (l Labels) Get(name string) *Label {
+	for _, label := range l {
+		if label.Name == name {
+			return label
+		}
+	}
+	return nil
 }
 
 func (l Labels) Names() []string {
@@ -104,3 +176,54 @@ func (l Labels) ToStrings() []string {
 	}
 	return ls
 }
+
+// Set is a combination of labels which together select a platform.
+type Set struct {
+	Names []string
+	Label *Label // Label is the platform of the set, its name is the joined names.
+}
+
+// ParseSet parses a set of names, and its platform like "docker://node:18" or "host".
+func ParseSet(names []string, platform string) (*Set, error) {
+	if len(names) == 0 {
+		return nil, errors.New("empty label set")
+	}
+	label, err := Parse(strings.Join(names, "+") + ":" + platform)
+	if err != nil {
+		return nil, err
+	}
+	return &Set{
+		Names: names,
+		Label: label,
+	}, nil
+}
+
+// Match reports whether all the labels of the set are in runsOn.
+func (s *Set) Match(runsOn []string) bool {
+	for _, name := range s.Names {
+		if !slices.Contains(runsOn, name) {
+			return false
+		}
+	}
+	return true
+}
+
+type Sets []*Set
+
+// Require reports whether the label of any set has schema.
+func (s Sets) Require(schema string) bool {
+	for _, set := range s {
+		if set.Label.Schema == schema {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Sets) RequireDocker() bool {
+	return s.Require(SchemeDocker)
+}
+
+func (s Sets) RequirePodman() bool {
+	return s.Require(SchemePodman)
+}
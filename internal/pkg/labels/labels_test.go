@@ -4,6 +4,7 @@
 package labels
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -25,6 +26,52 @@ func TestParse(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			args: "ubuntu:podman://node:18",
+			want: &Label{
+				Name:   "ubuntu",
+				Schema: "podman",
+				Arg:    "//node:18",
+			},
+			wantErr: false,
+		},
+		{
+			args: "systemd:lxc://ubuntu-template",
+			want: &Label{
+				Name:   "systemd",
+				Schema: "lxc",
+				Arg:    "//ubuntu-template",
+			},
+			wantErr: false,
+		},
+		{
+			args:    "systemd:lxc",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			args: "bigmem:ssh://ci@bigmem.example.com:2222",
+			want: &Label{
+				Name:   "bigmem",
+				Schema: "ssh",
+				Arg:    "//ci@bigmem.example.com:2222",
+			},
+			wantErr: false,
+		},
+		{
+			args:    "bigmem:ssh://bigmem.example.com",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			args: "ubuntu:k8s://node:18",
+			want: &Label{
+				Name:   "ubuntu",
+				Schema: "k8s",
+				Arg:    "//node:18",
+			},
+			wantErr: false,
+		},
 		{
 			args: "ubuntu:host",
 			want: &Label{
@@ -61,3 +108,51 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestLabels_Pick(t *testing.T) {
+	ls := Labels{}
+	for _, v := range []string{
+		"ubuntu-latest:docker://node:18",
+		"ubuntu-22.04:docker://node:18",
+		"ubuntu-20.04:docker://node:16",
+		"gpu:docker://node:18",
+		"linux:host",
+	} {
+		l, err := Parse(v)
+		require.NoError(t, err)
+		ls = append(ls, l)
+	}
+	gpuSet, err := ParseSet([]string{"ubuntu-22.04", "gpu"}, "docker://nvidia/cuda:12.4.1-runtime-ubuntu22.04")
+	require.NoError(t, err)
+	linuxGpuSet, err := ParseSet([]string{"linux", "gpu"}, "host")
+	require.NoError(t, err)
+	sets := []*Set{gpuSet, linuxGpuSet}
+
+	tests := []struct {
+		runsOn  []string
+		want    string
+		wantErr bool
+	}{
+		{runsOn: []string{"ubuntu-latest"}, want: "node:18"},
+		{runsOn: []string{"linux"}, want: "-self-hosted"},
+		{runsOn: []string{"ubuntu-latest", "ubuntu-22.04"}, want: "node:18"},
+		{runsOn: []string{"ubuntu-22.04", "gpu"}, want: "nvidia/cuda:12.4.1-runtime-ubuntu22.04"},
+		{runsOn: []string{"gpu", "ubuntu-latest", "ubuntu-22.04"}, want: "nvidia/cuda:12.4.1-runtime-ubuntu22.04"},
+		{runsOn: []string{"gpu", "linux"}, want: "-self-hosted"},
+		{runsOn: []string{"ubuntu-20.04", "ubuntu-22.04"}, wantErr: true},
+		{runsOn: []string{"ubuntu-latest", "windows"}, wantErr: true},
+		{runsOn: []string{"windows"}, wantErr: true},
+		{runsOn: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.runsOn, ","), func(t *testing.T) {
+			got, err := ls.Pick(tt.runsOn, sets...)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrNoMatch)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Platform())
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package metrics provides the Prometheus metrics exposed by the runner daemon.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "act_runner"
+
+var (
+	// FetchTaskTotal counts the FetchTask requests sent to the Gitea instance.
+	FetchTaskTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "poller",
+		Name:      "fetch_task_total",
+		Help:      "Total number of FetchTask requests.",
+	})
+	// FetchTaskErrorsTotal counts the failed FetchTask requests, partitioned by connect error code.
+	FetchTaskErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "poller",
+		Name:      "fetch_task_errors_total",
+		Help:      "Total number of failed FetchTask requests, partitioned by connect error code.",
+	}, []string{"code"})
+	// Draining is 1 if the poller is draining, otherwise 0.
+	Draining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "poller",
+		Name:      "draining",
+		Help:      "Whether the poller is draining (1) or fetching tasks (0).",
+	})
+	// RunningTasks is the number of tasks currently being run.
+	RunningTasks = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "runner",
+		Name:      "running_tasks",
+		Help:      "Number of tasks currently being run.",
+	})
+	// JobsTotal counts the finished jobs, partitioned by result.
+	JobsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "runner",
+		Name:      "jobs_total",
+		Help:      "Total number of finished jobs, partitioned by result.",
+	}, []string{"result"})
+	// JobDuration observes the duration of finished jobs, partitioned by result.
+	JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "runner",
+		Name:      "job_duration_seconds",
+		Help:      "Duration of finished jobs in seconds, partitioned by result.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 10800},
+	}, []string{"result"})
+	// PendingLogRows is the number of log rows waiting to be sent to the Gitea instance.
+	PendingLogRows = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "reporter",
+		Name:      "pending_log_rows",
+		Help:      "Number of log rows waiting to be sent to the Gitea instance.",
+	})
+	// ReportDuration observes the latency of the UpdateLog and UpdateTask requests, partitioned by method.
+	ReportDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "reporter",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of UpdateLog and UpdateTask requests in seconds, partitioned by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		FetchTaskTotal,
+		FetchTaskErrorsTotal,
+		Draining,
+		RunningTasks,
+		JobsTotal,
+		JobDuration,
+		PendingLogRows,
+		ReportDuration,
+	)
+}
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
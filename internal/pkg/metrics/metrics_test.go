@@ -0,0 +1,45 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	FetchTaskTotal.Inc()
+	FetchTaskErrorsTotal.WithLabelValues("unavailable").Inc()
+	JobsTotal.WithLabelValues("success").Inc()
+	JobDuration.WithLabelValues("success").Observe(3)
+	ReportDuration.WithLabelValues("UpdateLog").Observe(0.1)
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	for _, want := range []string{
+		"act_runner_poller_fetch_task_total 1",
+		`act_runner_poller_fetch_task_errors_total{code="unavailable"} 1`,
+		"act_runner_poller_draining 0",
+		"act_runner_runner_running_tasks 0",
+		`act_runner_runner_jobs_total{result="success"} 1`,
+		`act_runner_runner_job_duration_seconds_bucket{result="success",le="5"} 1`,
+		"act_runner_reporter_pending_log_rows 0",
+		`act_runner_reporter_request_duration_seconds_count{method="UpdateLog"} 1`,
+		"go_goroutines",
+	} {
+		assert.Contains(t, string(body), want)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package podman provides a client of the libpod API of Podman, for the features its Docker-compatible API doesn't have.
+package podman
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiVersion is the version of the libpod API used by the client, it's supported since Podman 4.0.
+const apiVersion = "v4.0.0"
+
+// Client talks to the libpod API of a Podman service.
+type Client struct {
+	host string
+	base string // base is the URL the paths of the API are relative to
+	http *http.Client
+}
+
+// NewClient returns a Client of the Podman service listening on host, like "unix:///run/podman/podman.sock" or "tcp://127.0.0.1:8080".
+func NewClient(host string) (*Client, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid podman host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		// the host of the URL doesn't matter, the socket is dialed instead
+		return &Client{host: host, base: "http://podman", http: &http.Client{Transport: transport}}, nil
+	case "tcp", "http":
+		return &Client{host: host, base: "http://" + u.Host, http: &http.Client{}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme of podman host %q", host)
+	}
+}
+
+// Close closes the idle connections of the client.
+func (c *Client) Close() {
+	c.http.CloseIdleConnections()
+}
+
+// Ping checks that the service is Podman, a Docker daemon doesn't know the libpod API.
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/libpod/_ping", nil)
+	if err != nil {
+		return fmt.Errorf("cannot ping the podman service, is it running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Libpod-API-Version") == "" {
+		return fmt.Errorf("%s is not a podman service, the libpod ping returned %s", c.host, resp.Status)
+	}
+	return nil
+}
+
+// PodSpec is the part of the specification of a pod used by the runner.
+type PodSpec struct {
+	Name         string            `json:"name"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	HostAdd      []string          `json:"hostadd,omitempty"` // HostAdd adds entries like "name:ip" to /etc/hosts of the pod.
+	PortMappings []PortMapping     `json:"portmappings,omitempty"`
+	Userns       *Namespace        `json:"userns,omitempty"`
+}
+
+// PortMapping publishes a port of the pod.
+type PortMapping struct {
+	HostIP        string `json:"host_ip,omitempty"`
+	ContainerPort uint16 `json:"container_port"`
+	HostPort      uint16 `json:"host_port,omitempty"` // HostPort is a random port if it's 0.
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+// Namespace is the mode of a namespace, like "keep-id" for a user namespace.
+type Namespace struct {
+	Mode  string `json:"nsmode"`
+	Value string `json:"value,omitempty"`
+}
+
+// CreatePod creates a pod with an infra container owning its namespaces, and returns the ID of the infra container.
+func (c *Client) CreatePod(ctx context.Context, spec PodSpec) (string, error) {
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/"+apiVersion+"/libpod/pods/create", body, http.StatusCreated, &created); err != nil {
+		return "", fmt.Errorf("cannot create pod %s: %w", spec.Name, err)
+	}
+
+	var inspect struct {
+		InfraContainerID string `json:"InfraContainerID"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/"+apiVersion+"/libpod/pods/"+url.PathEscape(created.ID)+"/json", nil, http.StatusOK, &inspect); err != nil {
+		return "", fmt.Errorf("cannot inspect pod %s: %w", spec.Name, err)
+	}
+	if inspect.InfraContainerID == "" {
+		return "", fmt.Errorf("pod %s has no infra container", spec.Name)
+	}
+	return inspect.InfraContainerID, nil
+}
+
+// RemovePod removes the pod with name and all its containers, it isn't an error if the pod doesn't exist.
+func (c *Client) RemovePod(ctx context.Context, name string) error {
+	err := c.call(ctx, http.MethodDelete, "/"+apiVersion+"/libpod/pods/"+url.PathEscape(name)+"?force=true", nil, http.StatusOK, nil)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot remove pod %s: %w", name, err)
+	}
+	return nil
+}
+
+// statusError is returned if the API answers with an unexpected status.
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.message)
+}
+
+func (c *Client) call(ctx context.Context, method, path string, body []byte, want int, out any) error {
+	resp, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != want {
+		// the errors of the API are like {"cause": "...", "message": "...", "response": 404}
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return &statusError{code: resp.StatusCode, message: apiErr.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.http.Do(req)
+}
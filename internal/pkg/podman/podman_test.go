@@ -0,0 +1,94 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	var created PodSpec
+	pods := map[string]bool{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /libpod/_ping", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Libpod-API-Version", "4.9.3")
+		_, _ = w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("POST /v4.0.0/libpod/pods/create", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		pods["pod-id"] = true
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"Id":"pod-id"}`))
+	})
+	mux.HandleFunc("GET /v4.0.0/libpod/pods/pod-id/json", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"Id":"pod-id","InfraContainerID":"infra-id"}`))
+	})
+	mux.HandleFunc("DELETE /v4.0.0/libpod/pods/{name}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("force"))
+		if !pods[r.PathValue("name")] {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"cause":"no such pod","message":"no pod with name or ID test-pod found: no such pod","response":404}`))
+			return
+		}
+		delete(pods, r.PathValue("name"))
+	})
+
+	// the client talks to the unix socket of the service
+	socket := filepath.Join(t.TempDir(), "podman.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	cli, err := NewClient("unix://" + socket)
+	require.NoError(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+
+	require.NoError(t, cli.Ping(ctx))
+
+	infraID, err := cli.CreatePod(ctx, PodSpec{
+		Name:         "test-pod",
+		Labels:       map[string]string{"owner": "runner"},
+		HostAdd:      []string{"db:127.0.0.1"},
+		PortMappings: []PortMapping{{ContainerPort: 5432, HostPort: 15432, Protocol: "tcp"}},
+		Userns:       &Namespace{Mode: "keep-id"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "infra-id", infraID)
+	assert.Equal(t, PodSpec{
+		Name:         "test-pod",
+		Labels:       map[string]string{"owner": "runner"},
+		HostAdd:      []string{"db:127.0.0.1"},
+		PortMappings: []PortMapping{{ContainerPort: 5432, HostPort: 15432, Protocol: "tcp"}},
+		Userns:       &Namespace{Mode: "keep-id"},
+	}, created)
+
+	require.NoError(t, cli.RemovePod(ctx, "pod-id"))
+	assert.Empty(t, pods)
+	assert.NoError(t, cli.RemovePod(ctx, "test-pod"), "a missing pod has been removed already")
+}
+
+func TestClient_Ping(t *testing.T) {
+	// a docker daemon doesn't know the libpod API
+	docker := httptest.NewServer(http.NotFoundHandler())
+	defer docker.Close()
+	cli, err := NewClient("tcp://" + docker.Listener.Addr().String())
+	require.NoError(t, err)
+	assert.ErrorContains(t, cli.Ping(context.Background()), "is not a podman service")
+
+	_, err = NewClient("npipe:////./pipe/podman")
+	assert.ErrorContains(t, err, "unsupported scheme")
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package reaper removes the containers, networks and volumes of tasks which aren't running anymore,
+// they're left behind if the runner is killed during a job.
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+
+	"gitea.com/gitea/act_runner/internal/pkg/egress"
+)
+
+const (
+	// NamePrefix is the prefix of the names of the resources of tasks, followed by the task id.
+	NamePrefix = "GITEA-ACTIONS-TASK-"
+	// OwnerLabel is the label of the containers and networks whose value is the uuid of the runner running them.
+	// Only the resources of the runner are removed, since runners may share a docker daemon.
+	OwnerLabel = "com.gitea.act-runner.uuid"
+)
+
+// Report lists the resources which have been removed.
+type Report struct {
+	Containers []string
+	Networks   []string
+	Volumes    []string
+}
+
+// Empty reports whether nothing has been removed.
+func (r *Report) Empty() bool {
+	return len(r.Containers) == 0 && len(r.Networks) == 0 && len(r.Volumes) == 0
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%d containers %v, %d networks %v, %d volumes %v",
+		len(r.Containers), r.Containers, len(r.Networks), r.Networks, len(r.Volumes), r.Volumes)
+}
+
+// Reaper removes the resources of the tasks of a runner which aren't running.
+type Reaper struct {
+	cli      client.APIClient
+	owner    string
+	iptables string
+	grace    time.Duration
+	running  func(id int64) bool
+}
+
+// New returns a reaper of the resources of the runner with uuid owner.
+// iptables is the command removing the chains of the egress networks, egress.DefaultIptables if it's empty.
+// Resources younger than grace are kept, since their task may be starting.
+// running reports whether a task is running on the runner.
+func New(cli client.APIClient, owner, iptables string, grace time.Duration, running func(id int64) bool) *Reaper {
+	if iptables == "" {
+		iptables = egress.DefaultIptables
+	}
+	return &Reaper{
+		cli:      cli,
+		owner:    owner,
+		iptables: iptables,
+		grace:    grace,
+		running:  running,
+	}
+}
+
+// NewFromEnv is like New, with a client of the docker daemon of DOCKER_HOST.
+func NewFromEnv(owner, iptables string, grace time.Duration, running func(id int64) bool) (*Reaper, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return New(cli, owner, iptables, grace, running), nil
+}
+
+// Run reaps now and then every interval until ctx is done, and logs what has been removed.
+func (r *Reaper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := r.Reap(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("failed to reap the resources of tasks which aren't running: %v", err)
+		}
+		if !report.Empty() {
+			log.Infof("reaped the resources of tasks which aren't running: %s", report)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap removes the resources of tasks which aren't running, the returned report is never nil.
+// The containers and networks are the ones labeled with the owner. act can't label the volumes,
+// so only the volumes of the tasks with a container or a network of the owner are removed.
+// The networks and volumes still in use can't be removed, so they're skipped silently.
+func (r *Reaper) Reap(ctx context.Context) (*Report, error) {
+	report := &Report{}
+	deadline := time.Now().Add(-r.grace)
+	owned := filters.NewArgs(filters.Arg("name", NamePrefix), filters.Arg("label", OwnerLabel+"="+r.owner))
+
+	containers, err := r.cli.ContainerList(ctx, container.ListOptions{All: true, Filters: owned})
+	if err != nil {
+		return report, fmt.Errorf("cannot list containers: %w", err)
+	}
+	networks, err := r.cli.NetworkList(ctx, types.NetworkListOptions{Filters: owned})
+	if err != nil {
+		return report, fmt.Errorf("cannot list networks: %w", err)
+	}
+	// the tasks of the owner, their volumes are removed with their containers and networks
+	tasks := map[int64]bool{}
+	for _, c := range containers {
+		if len(c.Names) > 0 {
+			if id, ok := TaskID(strings.TrimPrefix(c.Names[0], "/")); ok {
+				tasks[id] = true
+			}
+		}
+	}
+	for _, n := range networks {
+		if id, ok := TaskID(n.Name); ok {
+			tasks[id] = true
+		}
+	}
+
+	for _, c := range containers {
+		if len(c.Names) == 0 || !r.orphan(strings.TrimPrefix(c.Names[0], "/"), time.Unix(c.Created, 0), deadline) {
+			continue
+		}
+		if err := r.cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+			log.Warnf("cannot remove container %s: %v", c.Names[0], err)
+			continue
+		}
+		report.Containers = append(report.Containers, strings.TrimPrefix(c.Names[0], "/"))
+	}
+
+	volumes, err := r.cli.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("name", NamePrefix)),
+	})
+	if err != nil {
+		return report, fmt.Errorf("cannot list volumes: %w", err)
+	}
+	for _, v := range volumes.Volumes {
+		created, _ := time.Parse(time.RFC3339, v.CreatedAt)
+		if id, _ := TaskID(v.Name); !tasks[id] || !r.orphan(v.Name, created, deadline) {
+			continue
+		}
+		if err := r.cli.VolumeRemove(ctx, v.Name, false); err != nil {
+			log.Debugf("cannot remove volume %s: %v", v.Name, err)
+			continue
+		}
+		report.Volumes = append(report.Volumes, v.Name)
+	}
+
+	for _, n := range networks {
+		if !r.orphan(n.Name, n.Created, deadline) {
+			continue
+		}
+		if err := r.cli.NetworkRemove(ctx, n.ID); err != nil {
+			log.Debugf("cannot remove network %s: %v", n.Name, err)
+			continue
+		}
+		// the label only tells the rules exist, the command is the configured one since anyone may label a network
+		if _, ok := n.Labels[egress.Label]; ok {
+			if err := egress.RemoveRules(ctx, r.iptables, n.ID); err != nil {
+				log.Warnf("cannot remove the egress rules of network %s: %v", n.Name, err)
+			}
+		}
+		report.Networks = append(report.Networks, n.Name)
+	}
+	return report, nil
+}
+
+// orphan reports whether the resource with name created at created belongs to a task which isn't running.
+func (r *Reaper) orphan(name string, created, deadline time.Time) bool {
+	id, ok := TaskID(name)
+	return ok && !r.running(id) && created.Before(deadline)
+}
+
+// TaskID returns the id of the task of a resource named like "GITEA-ACTIONS-TASK-42_WORKFLOW-build_JOB-test".
+func TaskID(name string) (int64, bool) {
+	rest, ok := strings.CutPrefix(name, NamePrefix)
+	if !ok {
+		return 0, false
+	}
+	end := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+	if end >= 0 {
+		rest = rest[:end]
+	}
+	id, err := strconv.ParseInt(rest, 10, 64)
+	return id, err == nil
+}
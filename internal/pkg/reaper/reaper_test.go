@@ -0,0 +1,131 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker serves the lists of resources, and records the removals.
+type fakeDocker struct {
+	mu      sync.Mutex
+	filters []string
+	removed []string
+	inUse   map[string]bool
+}
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := time.Now().Add(-time.Hour)
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "")
+	if r.Method == http.MethodDelete {
+		if f.inUse[path] {
+			http.Error(w, `{"message":"in use"}`, http.StatusConflict)
+			return
+		}
+		f.removed = append(f.removed, path)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f.filters = append(f.filters, r.URL.Query().Get("filters"))
+	var body any
+	switch path {
+	case "/containers/json":
+		body = []map[string]any{
+			{"Id": "c41", "Names": []string{"/GITEA-ACTIONS-TASK-41_WORKFLOW-ci_JOB-test"}, "Created": old.Unix()},
+			{"Id": "c42", "Names": []string{"/GITEA-ACTIONS-TASK-42_WORKFLOW-ci_JOB-test"}, "Created": old.Unix()},
+			{"Id": "c43", "Names": []string{"/GITEA-ACTIONS-TASK-43_WORKFLOW-ci_JOB-test"}, "Created": time.Now().Unix()},
+		}
+	case "/networks":
+		body = []map[string]any{
+			{"Id": "n41", "Name": "GITEA-ACTIONS-TASK-41-network", "Created": old},
+			{"Id": "n44", "Name": "GITEA-ACTIONS-TASK-44-network", "Created": old},
+			{"Id": "0123456789abcdef", "Name": "GITEA-ACTIONS-TASK-45-network", "Created": old, "Labels": map[string]string{"com.gitea.act-runner.egress": "true"}},
+			{"Id": "n0", "Name": "GITEA-ACTIONS-TASK-network", "Created": old},
+		}
+	case "/volumes":
+		body = map[string]any{"Volumes": []map[string]any{
+			{"Name": "GITEA-ACTIONS-TASK-41_WORKFLOW-ci_JOB-test-env", "CreatedAt": old.Format(time.RFC3339)},
+			{"Name": "GITEA-ACTIONS-TASK-42_WORKFLOW-ci_JOB-test", "CreatedAt": old.Format(time.RFC3339)},
+			{"Name": "GITEA-ACTIONS-TASK-46_WORKFLOW-ci_JOB-test", "CreatedAt": old.Format(time.RFC3339)},
+		}}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func TestReaper_Reap(t *testing.T) {
+	// the fake iptables records its arguments
+	dir := t.TempDir()
+	iptables := filepath.Join(dir, "iptables")
+	require.NoError(t, os.WriteFile(iptables, []byte("#!/bin/sh\necho \"$@\" >> "+filepath.Join(dir, "args")+"\n"), 0o755))
+
+	f := &fakeDocker{inUse: map[string]bool{"/networks/n44": true}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithHTTPClient(server.Client()))
+	require.NoError(t, err)
+
+	r := New(cli, "runner-uuid", iptables, 10*time.Minute, func(id int64) bool { return id == 42 })
+	report, err := r.Reap(context.Background())
+	require.NoError(t, err)
+
+	// the volume of task 46 isn't removed, it has no container or network of the runner
+	assert.Equal(t, &Report{
+		Containers: []string{"GITEA-ACTIONS-TASK-41_WORKFLOW-ci_JOB-test"},
+		Networks:   []string{"GITEA-ACTIONS-TASK-41-network", "GITEA-ACTIONS-TASK-45-network"},
+		Volumes:    []string{"GITEA-ACTIONS-TASK-41_WORKFLOW-ci_JOB-test-env"},
+	}, report)
+	assert.Equal(t, []string{
+		"/containers/c41",
+		"/volumes/GITEA-ACTIONS-TASK-41_WORKFLOW-ci_JOB-test-env",
+		"/networks/n41",
+		"/networks/0123456789abcdef",
+	}, f.removed)
+	owned := `{"label":{"com.gitea.act-runner.uuid=runner-uuid":true},"name":{"GITEA-ACTIONS-TASK-":true}}`
+	assert.JSONEq(t, owned, f.filters[0])
+	assert.JSONEq(t, owned, f.filters[1])
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "-w -D DOCKER-USER -i br-0123456789ab -j EGRESS-br-0123456789ab\n"+
+		"-w -F EGRESS-br-0123456789ab\n"+
+		"-w -X EGRESS-br-0123456789ab\n", string(args))
+}
+
+func TestTaskID(t *testing.T) {
+	for name, want := range map[string]int64{
+		"GITEA-ACTIONS-TASK-42_WORKFLOW-ci_JOB-test": 42,
+		"GITEA-ACTIONS-TASK-7-network":               7,
+		"GITEA-ACTIONS-TASK-123":                     123,
+	} {
+		id, ok := TaskID(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, id, name)
+	}
+	for _, name := range []string{"GITEA-ACTIONS-TASK-network", "other-42", ""} {
+		_, ok := TaskID(name)
+		assert.False(t, ok, name)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxAnnotations is the number of annotations of each level kept by a task for the summary, the others are only counted,
+// so a step writing annotations in a loop can't exhaust the memory.
+const maxAnnotations = 50
+
+// annotation is the content of a ::error, ::warning or ::notice command.
+type annotation struct {
+	level   string // level is the command, "error", "warning" or "notice".
+	step    int    // step is the index of the step, or -1 if it has been written outside the steps.
+	message string
+
+	file      string
+	line      int
+	endLine   int
+	col       int
+	endColumn int
+	title     string
+}
+
+var (
+	propertyUnescaper = strings.NewReplacer("%25", "%", "%0D", "\r", "%0A", "\n", "%3A", ":", "%2C", ",")
+	messageUnescaper  = strings.NewReplacer("%25", "%", "%0D", "\r", "%0A", "\n")
+)
+
+// parseAnnotation parses the parameters and the value of a command like "::error file=app.go,line=1,title=Lint::message".
+// Unknown parameters and invalid numbers are ignored.
+func parseAnnotation(level string, step int, parameters, value string) *annotation {
+	a := &annotation{
+		level:   level,
+		step:    step,
+		message: messageUnescaper.Replace(value),
+	}
+	for _, param := range strings.Split(strings.TrimSpace(parameters), ",") {
+		k, v, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		v = propertyUnescaper.Replace(v)
+		switch strings.TrimSpace(k) {
+		case "file":
+			a.file = v
+		case "line":
+			a.line, _ = strconv.Atoi(v)
+		case "endLine":
+			a.endLine, _ = strconv.Atoi(v)
+		case "col":
+			a.col, _ = strconv.Atoi(v)
+		case "endColumn":
+			a.endColumn, _ = strconv.Atoi(v)
+		case "title":
+			a.title = v
+		}
+	}
+	return a
+}
+
+// location returns the position like "app.go:1:5-3:2", or "" if there is no file.
+func (a *annotation) location() string {
+	if a.file == "" {
+		return ""
+	}
+	loc := a.file
+	if a.line <= 0 {
+		return loc
+	}
+	loc += ":" + strconv.Itoa(a.line)
+	if a.col > 0 {
+		loc += ":" + strconv.Itoa(a.col)
+	}
+	if a.endLine > a.line {
+		loc += "-" + strconv.Itoa(a.endLine)
+		if a.endColumn > 0 {
+			loc += ":" + strconv.Itoa(a.endColumn)
+		}
+	} else if a.col > 0 && a.endColumn > a.col {
+		loc += "-" + strconv.Itoa(a.endColumn)
+	}
+	return loc
+}
+
+// lines renders the annotation, the first line is like "Error in step 2 at app.go:1: Title: message",
+// and the next lines are the next lines of the message.
+func (a *annotation) lines() []string {
+	head := strings.ToUpper(a.level[:1]) + a.level[1:]
+	if a.step >= 0 {
+		head += fmt.Sprintf(" in step %d", a.step+1)
+	}
+	if loc := a.location(); loc != "" {
+		head += " at " + loc
+	}
+	head += ": "
+	if a.title != "" {
+		head += a.title + ": "
+	}
+	lines := strings.Split(strings.ReplaceAll(a.message, "\r\n", "\n"), "\n")
+	lines[0] = head + lines[0]
+	for i := 1; i < len(lines); i++ {
+		lines[i] = "  " + lines[i]
+	}
+	return lines
+}
+
+// annotations are the annotations of a task.
+type annotations struct {
+	kept   []*annotation
+	counts map[string]int // counts are the numbers of annotations of each level, including the ones not kept
+}
+
+// add keeps a unless maxAnnotations of its level are kept already.
+func (as *annotations) add(a *annotation) {
+	if as.counts == nil {
+		as.counts = map[string]int{}
+	}
+	as.counts[a.level]++
+	if as.counts[a.level] <= maxAnnotations {
+		as.kept = append(as.kept, a)
+	}
+}
+
+// summary renders the annotations like:
+//
+//	Annotations: 1 error, 2 warnings
+//	Error in step 2 at app.go:1: message
+//	...
+//	3 more warnings are omitted
+func (as *annotations) summary() []string {
+	if len(as.kept) == 0 {
+		return nil
+	}
+	var parts, omitted []string
+	for _, level := range []string{"error", "warning", "notice"} {
+		switch n := as.counts[level]; n {
+		case 0:
+		case 1:
+			parts = append(parts, "1 "+level)
+		default:
+			parts = append(parts, fmt.Sprintf("%d %ss", n, level))
+		}
+		if more := as.counts[level] - maxAnnotations; more == 1 {
+			omitted = append(omitted, "1 more "+level+" is omitted")
+		} else if more > 1 {
+			omitted = append(omitted, fmt.Sprintf("%d more %ss are omitted", more, level))
+		}
+	}
+
+	summary := []string{"Annotations: " + strings.Join(parts, ", ")}
+	for _, a := range as.kept {
+		summary = append(summary, a.lines()...)
+	}
+	return append(summary, omitted...)
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package report
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAnnotation(t *testing.T) {
+	a := parseAnnotation("error", 1, " file=src/app.go,line=42,col=5,endLine=48,endColumn=2,title=Lint%3A vet%2C strict,unknown=1", "Gosh%0Asecond line")
+	assert.Equal(t, &annotation{
+		level:     "error",
+		step:      1,
+		message:   "Gosh\nsecond line",
+		file:      "src/app.go",
+		line:      42,
+		endLine:   48,
+		col:       5,
+		endColumn: 2,
+		title:     "Lint: vet, strict",
+	}, a)
+	assert.Equal(t, []string{
+		"Error in step 2 at src/app.go:42:5-48:2: Lint: vet, strict: Gosh",
+		"  second line",
+	}, a.lines())
+
+	a = parseAnnotation("notice", -1, "", "just a message")
+	assert.Equal(t, []string{"Notice: just a message"}, a.lines())
+
+	a = parseAnnotation("warning", 0, " file=a.go,line=x", "message")
+	assert.Equal(t, []string{"Warning in step 1 at a.go: message"}, a.lines())
+}
+
+func TestAnnotationSummary(t *testing.T) {
+	assert.Nil(t, (&annotations{}).summary())
+
+	r := &Reporter{logReplacer: strings.NewReplacer()}
+	main := map[string]interface{}{"stage": "Main", "stepNumber": 2}
+	for _, line := range []string{
+		"::error file=file.name,line=42,endLine=48,title=Cool Title::Gosh, that's not going to work",
+		"::warning::deprecated",
+		"::warning file=go.mod::old version",
+		"::notice::hello",
+	} {
+		r.parseLogRow(&log.Entry{Message: line, Data: main})
+	}
+	r.parseLogRow(&log.Entry{Message: "::error::post", Data: map[string]interface{}{"stage": "Post", "stepNumber": 2}})
+
+	assert.Equal(t, []string{
+		"Annotations: 2 errors, 2 warnings, 1 notice",
+		"Error in step 3 at file.name:42-48: Cool Title: Gosh, that's not going to work",
+		"Warning in step 3: deprecated",
+		"Warning in step 3 at go.mod: old version",
+		"Notice in step 3: hello",
+		"Error: post",
+	}, r.annotations.summary())
+
+	many := &annotations{}
+	for i := 0; i < maxAnnotations+3; i++ {
+		many.add(parseAnnotation("warning", -1, "", fmt.Sprint(i)))
+	}
+	many.add(parseAnnotation("error", -1, "", "last"))
+	assert.Len(t, many.kept, maxAnnotations+1, "only maxAnnotations of each level are kept")
+	summary := many.summary()
+	assert.Len(t, summary, maxAnnotations+3)
+	assert.Equal(t, fmt.Sprintf("Annotations: 1 error, %d warnings", maxAnnotations+3), summary[0])
+	assert.Equal(t, "Error: last", summary[len(summary)-2])
+	assert.Equal(t, "3 more warnings are omitted", summary[len(summary)-1])
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package report
+
+import (
+	"strings"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	groupMarker    = "::group::"
+	endGroupMarker = "::endgroup::"
+)
+
+// markerPrefixes are the prefixes of the rows folded by the frontend.
+var markerPrefixes = []string{groupMarker, endGroupMarker, "##[group]", "##[endgroup]"}
+
+// stepGroups tracks the groups of a step, or of the rows outside the steps.
+// The boundaries of the groups aren't recorded next to StepState.LogIndex and LogLength, since the proto has no field for them
+// (actions-proto-go v0.4.0), the frontend only finds the groups by the markers in the rows of the step.
+type stepGroups struct {
+	depth   int  // depth is the number of open groups, the frontend can't nest groups so only the outermost one is rendered.
+	dropped bool // dropped is whether the marker of the outermost group has been dropped, so is its end marker.
+}
+
+// groupsOf returns the groups of the step with index step, or -1 for the rows outside the steps.
+func (r *Reporter) groupsOf(step int) *stepGroups {
+	if r.groups == nil {
+		r.groups = map[int]*stepGroups{}
+	}
+	g, ok := r.groups[step]
+	if !ok {
+		g = &stepGroups{}
+		r.groups[step] = g
+	}
+	return g
+}
+
+// openGroup handles ::group::title, and returns the content of its row.
+// The row must be appended next, so appendRow can tell whether the marker has been dropped.
+func (r *Reporter) openGroup(step int, title string) string {
+	g := r.groupsOf(step)
+	g.depth++
+	if g.depth > 1 {
+		return title
+	}
+	g.dropped = false
+	r.openingGroup = g
+	return groupMarker + title
+}
+
+// closeGroup handles ::endgroup::, and returns the content of its row, or nil if it closes no rendered group.
+func (r *Reporter) closeGroup(step int) *string {
+	g := r.groupsOf(step)
+	if g.depth == 0 {
+		return nil
+	}
+	g.depth--
+	if g.depth > 0 || g.dropped {
+		return nil
+	}
+	marker := endGroupMarker
+	return &marker
+}
+
+// closeDanglingGroup returns the row closing the group of step left open, or nil if there is none.
+// The row must be appended next.
+func (r *Reporter) closeDanglingGroup(step int, t *timestamppb.Timestamp) *runnerv1.LogRow {
+	g := r.groupsOf(step)
+	if g.depth == 0 {
+		return nil
+	}
+	g.depth = 1
+	marker := r.closeGroup(step)
+	if marker == nil {
+		return nil
+	}
+	return &runnerv1.LogRow{Time: t, Content: *marker}
+}
+
+// closeDanglingGroups closes the groups left open when the task ends, it must be called with stateMu held.
+// The groups of a step are closed only if its rows are the last ones, the others can't be extended anymore.
+func (r *Reporter) closeDanglingGroups(t *timestamppb.Timestamp) {
+	next := int64(r.logOffset + len(r.logRows))
+	for i, step := range r.state.Steps {
+		if step.LogLength == 0 || step.LogIndex+step.LogLength != next {
+			continue
+		}
+		if row := r.closeDanglingGroup(i, t); row != nil {
+			r.pushRow(row)
+			step.LogLength++
+			next++
+		}
+	}
+	if row := r.closeDanglingGroup(-1, t); row != nil {
+		r.pushRow(row)
+	}
+}
+
+// isMarker reports whether the frontend folds the row with content.
+func isMarker(content string) bool {
+	for _, prefix := range markerPrefixes {
+		if strings.HasPrefix(content, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeMarker escapes content which isn't a group command but would be folded by the frontend.
+func escapeMarker(content string) string {
+	if isMarker(content) {
+		return " " + content
+	}
+	return content
+}
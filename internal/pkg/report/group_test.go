@@ -0,0 +1,121 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package report
+
+import (
+	"context"
+	"testing"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"github.com/nektos/act/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"gitea.com/gitea/act_runner/internal/pkg/client/mocks"
+)
+
+func newGroupReporter(t *testing.T, steps int) *Reporter {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	taskCtx, err := structpb.NewStruct(map[string]interface{}{})
+	require.NoError(t, err)
+	reporter := NewReporter(ctx, cancel, mocks.NewClient(t), &runnerv1.Task{Context: taskCtx})
+	reporter.ResetSteps(steps)
+	return reporter
+}
+
+func contents(rows []*runnerv1.LogRow) []string {
+	var contents []string
+	for _, row := range rows {
+		contents = append(contents, row.Content)
+	}
+	return contents
+}
+
+func TestReporter_Groups(t *testing.T) {
+	reporter := newGroupReporter(t, 2)
+	fire := func(step int, lines ...string) {
+		for _, line := range lines {
+			require.NoError(t, reporter.Fire(&log.Entry{Message: line, Data: log.Fields{"stage": "Main", "stepNumber": step, "raw_output": true}}))
+		}
+	}
+
+	fire(0,
+		"::endgroup::",
+		"::group::Build",
+		"::group::Inner",
+		"compiling",
+		"::endgroup::",
+		"::stop-commands::token",
+		"::endgroup::",
+		"::token::",
+		"linking",
+	)
+	require.NoError(t, reporter.Fire(&log.Entry{Data: log.Fields{"stage": "Main", "stepNumber": 0, "stepResult": model.StepStatusSuccess}}))
+	fire(1, "::group::Test", "ok", "::endgroup::", "##[group]not a command")
+
+	assert.Equal(t, []string{
+		"::group::Build",
+		"Inner",
+		"compiling",
+		" ::endgroup::",
+		"linking",
+		"::endgroup::",
+		"::group::Test",
+		"ok",
+		"::endgroup::",
+		" ##[group]not a command",
+	}, contents(reporter.logRows))
+
+	steps := reporter.state.Steps
+	assert.EqualValues(t, 0, steps[0].LogIndex)
+	assert.EqualValues(t, 6, steps[0].LogLength)
+	assert.EqualValues(t, 6, steps[1].LogIndex)
+	assert.EqualValues(t, 4, steps[1].LogLength)
+	assert.Zero(t, reporter.groups[0].depth)
+	assert.Zero(t, reporter.groups[1].depth)
+}
+
+func TestReporter_closeDanglingGroups(t *testing.T) {
+	reporter := newGroupReporter(t, 1)
+	for _, line := range []string{"::group::Download", "50%"} {
+		require.NoError(t, reporter.Fire(&log.Entry{Message: line, Data: log.Fields{"stage": "Main", "stepNumber": 0, "raw_output": true}}))
+	}
+
+	// the task is cancelled during the step
+	reporter.closeDanglingGroups(nil)
+	assert.Equal(t, []string{"::group::Download", "50%", "::endgroup::"}, contents(reporter.logRows))
+	assert.EqualValues(t, 3, reporter.state.Steps[0].LogLength)
+	assert.Zero(t, reporter.groups[0].depth)
+}
+
+func TestReporter_DroppedGroup(t *testing.T) {
+	reporter := newGroupReporter(t, 1)
+	reporter.maxBufferedRows = 2
+	fire := func(lines ...string) {
+		for _, line := range lines {
+			require.NoError(t, reporter.Fire(&log.Entry{Message: line, Data: log.Fields{"stage": "Main", "stepNumber": 0, "raw_output": true}}))
+		}
+	}
+
+	// the end marker of a group kept is written beyond the cap, the marker of the next group is dropped and so is its end marker
+	fire("::group::Kept", "a", "::endgroup::", "::group::Dropped", "b", "::endgroup::")
+	assert.Equal(t, []string{
+		"::group::Kept",
+		"a",
+		"::endgroup::",
+		"The log is truncated, since Gitea doesn't receive it as fast as it's written",
+	}, contents(reporter.logRows))
+
+	// the rows have been sent, the group left open is closed at the end of the step
+	reporter.logRows = nil
+	reporter.logBytes = 0
+	reporter.maxBufferedRows = 10
+	fire("::group::Open", "c")
+	require.NoError(t, reporter.Fire(&log.Entry{Data: log.Fields{"stage": "Main", "stepNumber": 0, "stepResult": model.StepStatusSuccess}}))
+	assert.Equal(t, []string{"2 lines of the log have been dropped", "::group::Open", "c", "::endgroup::"}, contents(reporter.logRows))
+	assert.EqualValues(t, 8, reporter.state.Steps[0].LogLength)
+}
@@ -5,10 +5,12 @@ package report
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
@@ -19,18 +21,38 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"gitea.com/gitea/act_runner/internal/pkg/client"
+	"gitea.com/gitea/act_runner/internal/pkg/metrics"
+)
+
+const (
+	// maxBatchRows and maxBatchBytes bound the rows of a request to Gitea, proxies often limit the size of requests.
+	maxBatchRows  = 5000
+	maxBatchBytes = 512 * 1024
+	// maxBatchesPerReport is the number of batches sent by a periodic report, so bursts are sent quickly.
+	maxBatchesPerReport = 8
+	// maxBufferedRows and maxBufferedBytes bound the rows waiting to be sent, rows beyond them are dropped,
+	// which is logged in their place.
+	maxBufferedRows  = 100000
+	maxBufferedBytes = 32 * 1024 * 1024
+	// reportInterval is the interval of the periodic reports, the log is sent earlier once a batch is full.
+	reportInterval = time.Second
+	// maxReportBackoff bounds the interval of the reports, which is doubled after each failure.
+	maxReportBackoff = 30 * time.Second
 )
 
 type Reporter struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	closed  bool
+	closed  atomic.Bool
 	client  client.Client
 	clientM sync.Mutex
 
 	logOffset   int
 	logRows     []*runnerv1.LogRow
+	logPending  int // logPending is the number of log rows last accounted in metrics.PendingLogRows
+	logBytes    int // logBytes is the size of the contents of logRows
+	logDropped  int // logDropped is the number of rows dropped since the buffer is full
 	logReplacer *strings.Replacer
 	oldnew      []string
 
@@ -38,8 +60,25 @@ type Reporter struct {
 	stateMu sync.RWMutex
 	outputs sync.Map
 
+	annotations      annotations
+	summaries        []*stepSummary
+	summariesOmitted int // summariesOmitted is the number of summaries beyond maxSummaries
+	groups           map[int]*stepGroups
+	openingGroup     *stepGroups // openingGroup is the groups whose marker is the row appended next
+
+	spool *Spool // spool is guarded by clientM.
+
 	debugOutputEnabled  bool
 	stopCommandEndToken string
+
+	maxBatchRows     int
+	maxBatchBytes    int
+	maxBufferedRows  int
+	maxBufferedBytes int
+
+	flush            chan struct{} // flush is signaled when the pending rows fill a batch
+	reportInterval   time.Duration
+	maxReportBackoff time.Duration
 }
 
 func NewReporter(ctx context.Context, cancel context.CancelFunc, client client.Client, task *runnerv1.Task) *Reporter {
@@ -63,6 +102,13 @@ func NewReporter(ctx context.Context, cancel context.CancelFunc, client client.C
 		state: &runnerv1.TaskState{
 			Id: task.Id,
 		},
+		maxBatchRows:     maxBatchRows,
+		maxBatchBytes:    maxBatchBytes,
+		maxBufferedRows:  maxBufferedRows,
+		maxBufferedBytes: maxBufferedBytes,
+		flush:            make(chan struct{}, 1),
+		reportInterval:   reportInterval,
+		maxReportBackoff: maxReportBackoff,
 	}
 
 	if task.Secrets["ACTIONS_STEP_DEBUG"] == "true" {
@@ -72,6 +118,13 @@ func NewReporter(ctx context.Context, cancel context.CancelFunc, client client.C
 	return rv
 }
 
+// SetSpool keeps the logs and the state on s until Gitea has received them.
+func (r *Reporter) SetSpool(s *Spool) {
+	r.clientM.Lock()
+	defer r.clientM.Unlock()
+	r.spool = s
+}
+
 func (r *Reporter) ResetSteps(l int) {
 	r.stateMu.Lock()
 	defer r.stateMu.Unlock()
@@ -86,13 +139,6 @@ func (r *Reporter) Levels() []log.Level {
 	return log.AllLevels
 }
 
-func appendIfNotNil[T any](s []*T, v *T) []*T {
-	if v != nil {
-		return append(s, v)
-	}
-	return s
-}
-
 func (r *Reporter) Fire(entry *log.Entry) error {
 	r.stateMu.Lock()
 	defer r.stateMu.Unlock()
@@ -109,6 +155,9 @@ func (r *Reporter) Fire(entry *log.Entry) error {
 	if stage != "Main" {
 		if v, ok := entry.Data["jobResult"]; ok {
 			if jobResult, ok := r.parseResult(v); ok {
+				if r.state.Result == runnerv1.Result_RESULT_UNSPECIFIED {
+					r.observeJob(jobResult, timestamp)
+				}
 				r.state.Result = jobResult
 				r.state.StoppedAt = timestamppb.New(timestamp)
 				for _, s := range r.state.Steps {
@@ -122,20 +171,19 @@ func (r *Reporter) Fire(entry *log.Entry) error {
 			}
 		}
 		if !r.duringSteps() {
-			r.logRows = appendIfNotNil(r.logRows, r.parseLogRow(entry))
+			r.appendRow(r.parseLogRow(entry))
 		}
 		return nil
 	}
 
 	var step *runnerv1.StepState
-	if v, ok := entry.Data["stepNumber"]; ok {
-		if v, ok := v.(int); ok && len(r.state.Steps) > v {
-			step = r.state.Steps[v]
-		}
+	stepNumber, ok := entry.Data["stepNumber"].(int)
+	if ok && stepNumber >= 0 && len(r.state.Steps) > stepNumber {
+		step = r.state.Steps[stepNumber]
 	}
 	if step == nil {
 		if !r.duringSteps() {
-			r.logRows = appendIfNotNil(r.logRows, r.parseLogRow(entry))
+			r.appendRow(r.parseLogRow(entry))
 		}
 		return nil
 	}
@@ -149,15 +197,20 @@ func (r *Reporter) Fire(entry *log.Entry) error {
 				if step.LogLength == 0 {
 					step.LogIndex = int64(r.logOffset + len(r.logRows))
 				}
-				step.LogLength++
-				r.logRows = append(r.logRows, row)
+				step.LogLength += int64(r.appendRow(row))
 			}
 		}
 	} else if !r.duringSteps() {
-		r.logRows = appendIfNotNil(r.logRows, r.parseLogRow(entry))
+		r.appendRow(r.parseLogRow(entry))
 	}
 	if v, ok := entry.Data["stepResult"]; ok {
 		if stepResult, ok := r.parseResult(v); ok {
+			if row := r.closeDanglingGroup(stepNumber, timestamppb.New(timestamp)); row != nil {
+				if step.LogLength == 0 {
+					step.LogIndex = int64(r.logOffset + len(r.logRows))
+				}
+				step.LogLength += int64(r.appendRow(row))
+			}
 			if step.LogLength == 0 {
 				step.LogIndex = int64(r.logOffset + len(r.logRows))
 			}
@@ -169,18 +222,52 @@ func (r *Reporter) Fire(entry *log.Entry) error {
 	return nil
 }
 
+// RunDaemon reports the log and the state in the background until the reporter is closed or its context is done.
+// They're reported every second, and the log is sent right away once the pending rows fill a batch.
+// The reports are slowed down while they fail, so a struggling Gitea isn't flooded.
 func (r *Reporter) RunDaemon() {
-	if r.closed {
-		return
-	}
-	if r.ctx.Err() != nil {
-		return
-	}
+	go r.runDaemon()
+}
 
-	_ = r.ReportLog(false)
-	_ = r.ReportState()
+func (r *Reporter) runDaemon() {
+	delay := r.reportInterval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		flush := r.flush
+		if delay > r.reportInterval {
+			// don't flush early while backing off
+			flush = nil
+		}
+		tick := false
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-timer.C:
+			tick = true
+		case <-flush:
+		}
+		if r.closed.Load() {
+			return
+		}
+
+		err := r.ReportLog(false)
+		if tick {
+			err = errors.Join(err, r.ReportState())
+		}
+		if next := r.backoff(delay, err); tick || next != delay {
+			delay = next
+			timer.Reset(delay)
+		}
+	}
+}
 
-	time.AfterFunc(time.Second, r.RunDaemon)
+// backoff returns the interval of the reports after one with err, which was reported at interval delay.
+func (r *Reporter) backoff(delay time.Duration, err error) time.Duration {
+	if err == nil {
+		return r.reportInterval
+	}
+	return min(2*delay, r.maxReportBackoff)
 }
 
 func (r *Reporter) Logf(format string, a ...interface{}) {
@@ -192,7 +279,7 @@ func (r *Reporter) Logf(format string, a ...interface{}) {
 
 func (r *Reporter) logf(format string, a ...interface{}) {
 	if !r.duringSteps() {
-		r.logRows = append(r.logRows, &runnerv1.LogRow{
+		r.appendRow(&runnerv1.LogRow{
 			Time:    timestamppb.Now(),
 			Content: fmt.Sprintf(format, a...),
 		})
@@ -220,9 +307,18 @@ func (r *Reporter) SetOutputs(outputs map[string]string) {
 }
 
 func (r *Reporter) Close(lastWords string) error {
-	r.closed = true
+	r.closed.Store(true)
 
 	r.stateMu.Lock()
+	r.closeDanglingGroups(timestamppb.Now())
+	r.pushDropped(timestamppb.Now())
+	r.pushSummaries()
+	for _, line := range r.annotations.summary() {
+		r.pushRow(&runnerv1.LogRow{
+			Time:    timestamppb.Now(),
+			Content: strings.ToValidUTF8(r.logReplacer.Replace(line), "?"),
+		})
+	}
 	if r.state.Result == runnerv1.Result_RESULT_UNSPECIFIED {
 		if lastWords == "" {
 			lastWords = "Early termination"
@@ -233,27 +329,57 @@ func (r *Reporter) Close(lastWords string) error {
 			}
 		}
 		r.state.Result = runnerv1.Result_RESULT_FAILURE
-		r.logRows = append(r.logRows, &runnerv1.LogRow{
+		r.pushRow(&runnerv1.LogRow{
 			Time:    timestamppb.Now(),
 			Content: lastWords,
 		})
 		r.state.StoppedAt = timestamppb.Now()
+		r.observeJob(r.state.Result, r.state.StoppedAt.AsTime())
 	} else if lastWords != "" {
-		r.logRows = append(r.logRows, &runnerv1.LogRow{
+		r.pushRow(&runnerv1.LogRow{
 			Time:    timestamppb.Now(),
 			Content: lastWords,
 		})
 	}
 	r.stateMu.Unlock()
 
-	return retry.Do(func() error {
+	// spool everything first, the context may be done already
+	r.clientM.Lock()
+	r.stateMu.RLock()
+	rows := r.logRows
+	r.stateMu.RUnlock()
+	r.spoolRows(rows)
+	r.spoolState(r.taskRequest())
+	r.clientM.Unlock()
+
+	err := retry.Do(func() error {
 		if err := r.ReportLog(true); err != nil {
 			return err
 		}
 		return r.ReportState()
 	}, retry.Context(r.ctx))
+
+	// the reporter is done, rows that haven't been sent by now are lost
+	r.clientM.Lock()
+	r.updatePendingMetric(0)
+	if r.spool != nil {
+		if err == nil {
+			if err := r.spool.Remove(); err != nil {
+				log.Warnf("cannot remove the spool of task %d: %v", r.state.Id, err)
+			}
+		} else {
+			_ = r.spool.Close()
+			log.Warnf("the logs and the state of task %d are kept in the spool to be sent later: %v", r.state.Id, err)
+		}
+		r.spool = nil
+	}
+	r.clientM.Unlock()
+
+	return err
 }
 
+// ReportLog sends the pending rows in batches. Unless noMore, it sends up to maxBatchesPerReport batches,
+// and leaves the others to the next report.
 func (r *Reporter) ReportLog(noMore bool) error {
 	r.clientM.Lock()
 	defer r.clientM.Unlock()
@@ -261,54 +387,82 @@ func (r *Reporter) ReportLog(noMore bool) error {
 	r.stateMu.RLock()
 	rows := r.logRows
 	r.stateMu.RUnlock()
+	r.updatePendingMetric(len(rows))
+	r.spoolRows(rows)
 
-	resp, err := r.client.UpdateLog(r.ctx, connect.NewRequest(&runnerv1.UpdateLogRequest{
-		TaskId: r.state.Id,
-		Index:  int64(r.logOffset),
-		Rows:   rows,
-		NoMore: noMore,
-	}))
-	if err != nil {
-		return err
-	}
-
-	ack := int(resp.Msg.AckIndex)
-	if ack < r.logOffset {
-		return fmt.Errorf("submitted logs are lost")
-	}
+	for batches := 0; batches == 0 || len(rows) > 0; batches++ {
+		if !noMore && batches == maxBatchesPerReport {
+			return nil
+		}
+		batch := r.batch(rows)
+
+		start := time.Now()
+		resp, err := r.client.UpdateLog(r.ctx, connect.NewRequest(&runnerv1.UpdateLogRequest{
+			TaskId: r.state.Id,
+			Index:  int64(r.logOffset),
+			Rows:   batch,
+			NoMore: noMore && len(batch) == len(rows),
+		}))
+		metrics.ReportDuration.WithLabelValues("UpdateLog").Observe(time.Since(start).Seconds())
+		if err != nil {
+			return err
+		}
 
-	r.stateMu.Lock()
-	r.logRows = r.logRows[ack-r.logOffset:]
-	r.logOffset = ack
-	r.stateMu.Unlock()
+		ack := int(resp.Msg.AckIndex)
+		if ack < r.logOffset {
+			return fmt.Errorf("submitted logs are lost")
+		}
+		acked := ack - r.logOffset
 
-	if noMore && ack < r.logOffset+len(rows) {
-		return fmt.Errorf("not all logs are submitted")
+		r.stateMu.Lock()
+		for _, row := range r.logRows[:acked] {
+			r.logBytes -= len(row.Content)
+		}
+		r.logRows = r.logRows[acked:]
+		r.logOffset = ack
+		pending := len(r.logRows)
+		r.stateMu.Unlock()
+		r.updatePendingMetric(pending)
+
+		if acked < len(batch) {
+			if noMore {
+				return fmt.Errorf("not all logs are submitted")
+			}
+			return nil
+		}
+		rows = rows[acked:]
 	}
 
 	return nil
 }
 
+// batch returns the first rows which fit in a request, at least one.
+func (r *Reporter) batch(rows []*runnerv1.LogRow) []*runnerv1.LogRow {
+	return batchRows(rows, r.maxBatchRows, r.maxBatchBytes)
+}
+
+// batchRows returns the first rows which fit in maxRows and maxBytes, at least one.
+func batchRows(rows []*runnerv1.LogRow, maxRows, maxBytes int) []*runnerv1.LogRow {
+	size := 0
+	for i, row := range rows {
+		size += len(row.Content)
+		if i == maxRows || i > 0 && size > maxBytes {
+			return rows[:i]
+		}
+	}
+	return rows
+}
+
 func (r *Reporter) ReportState() error {
 	r.clientM.Lock()
 	defer r.clientM.Unlock()
 
-	r.stateMu.RLock()
-	state := proto.Clone(r.state).(*runnerv1.TaskState)
-	r.stateMu.RUnlock()
+	req := r.taskRequest()
+	r.spoolState(req)
 
-	outputs := make(map[string]string)
-	r.outputs.Range(func(k, v interface{}) bool {
-		if val, ok := v.(string); ok {
-			outputs[k.(string)] = val
-		}
-		return true
-	})
-
-	resp, err := r.client.UpdateTask(r.ctx, connect.NewRequest(&runnerv1.UpdateTaskRequest{
-		State:   state,
-		Outputs: outputs,
-	}))
+	start := time.Now()
+	resp, err := r.client.UpdateTask(r.ctx, connect.NewRequest(req))
+	metrics.ReportDuration.WithLabelValues("UpdateTask").Observe(time.Since(start).Seconds())
 	if err != nil {
 		return err
 	}
@@ -335,6 +489,127 @@ func (r *Reporter) ReportState() error {
 	return nil
 }
 
+// taskRequest returns the request to report the state and the outputs which haven't been sent.
+func (r *Reporter) taskRequest() *runnerv1.UpdateTaskRequest {
+	r.stateMu.RLock()
+	state := proto.Clone(r.state).(*runnerv1.TaskState)
+	r.stateMu.RUnlock()
+
+	outputs := make(map[string]string)
+	r.outputs.Range(func(k, v interface{}) bool {
+		if val, ok := v.(string); ok {
+			outputs[k.(string)] = val
+		}
+		return true
+	})
+	return &runnerv1.UpdateTaskRequest{
+		State:   state,
+		Outputs: outputs,
+	}
+}
+
+// spoolRows appends the pending rows to the spool, if any. It must be called with clientM held.
+func (r *Reporter) spoolRows(rows []*runnerv1.LogRow) {
+	if r.spool == nil {
+		return
+	}
+	if err := r.spool.AppendRows(r.logOffset, rows); err != nil {
+		r.dropSpool(err)
+	}
+}
+
+// spoolState saves req and the index of the pending rows to the spool, if any. It must be called with clientM held.
+func (r *Reporter) spoolState(req *runnerv1.UpdateTaskRequest) {
+	if r.spool == nil {
+		return
+	}
+	if err := r.spool.SaveState(r.logOffset, req); err != nil {
+		r.dropSpool(err)
+	}
+}
+
+// dropSpool removes the spool after an error, since an incomplete spool can't be replayed.
+func (r *Reporter) dropSpool(err error) {
+	log.Warnf("cannot spool the logs and the state of task %d, they won't be sent again if they're lost: %v", r.state.Id, err)
+	_ = r.spool.Remove()
+	r.spool = nil
+}
+
+// updatePendingMetric accounts the number of pending log rows of this reporter in metrics.PendingLogRows.
+// It must be called with clientM held.
+func (r *Reporter) updatePendingMetric(pending int) {
+	metrics.PendingLogRows.Add(float64(pending - r.logPending))
+	r.logPending = pending
+}
+
+func (r *Reporter) observeJob(result runnerv1.Result, stoppedAt time.Time) {
+	label := strings.ToLower(strings.TrimPrefix(result.String(), "RESULT_"))
+	metrics.JobsTotal.WithLabelValues(label).Inc()
+	if r.state.StartedAt != nil {
+		metrics.JobDuration.WithLabelValues(label).Observe(stoppedAt.Sub(r.state.StartedAt.AsTime()).Seconds())
+	}
+}
+
+// appendRow appends row to the pending rows, and returns the number of rows which have been appended.
+// Once too many rows are pending, the next rows are dropped until the rows have been sent,
+// a row telling the log is truncated takes their place, and another one tells how many rows have been dropped.
+// It must be called with stateMu held.
+func (r *Reporter) appendRow(row *runnerv1.LogRow) int {
+	if row == nil {
+		return 0
+	}
+	opening := r.openingGroup
+	r.openingGroup = nil
+	if row.Content == endGroupMarker {
+		// the end markers are only written for the group markers which have been kept, so they're bounded by the cap too,
+		// and the groups are closed whatever happens
+		r.pushRow(row)
+		return 1
+	}
+	if len(r.logRows) >= r.maxBufferedRows || r.logBytes+len(row.Content) > r.maxBufferedBytes {
+		if opening != nil {
+			opening.dropped = true
+		}
+		r.logDropped++
+		if r.logDropped == 1 {
+			r.pushRow(&runnerv1.LogRow{
+				Time:    row.Time,
+				Content: "The log is truncated, since Gitea doesn't receive it as fast as it's written",
+			})
+			return 1
+		}
+		return 0
+	}
+	n := r.pushDropped(row.Time)
+	r.pushRow(row)
+	return n + 1
+}
+
+// pushDropped appends a row telling how many rows have been dropped, if any, and returns the number of rows appended.
+func (r *Reporter) pushDropped(t *timestamppb.Timestamp) int {
+	if r.logDropped == 0 {
+		return 0
+	}
+	r.pushRow(&runnerv1.LogRow{
+		Time:    t,
+		Content: fmt.Sprintf("%d lines of the log have been dropped", r.logDropped),
+	})
+	r.logDropped = 0
+	return 1
+}
+
+// pushRow appends row to the pending rows regardless of their number.
+func (r *Reporter) pushRow(row *runnerv1.LogRow) {
+	r.logRows = append(r.logRows, row)
+	r.logBytes += len(row.Content)
+	if len(r.logRows) >= r.maxBatchRows || r.logBytes >= r.maxBatchBytes {
+		select {
+		case r.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
 func (r *Reporter) duringSteps() bool {
 	if steps := r.state.Steps; len(steps) == 0 {
 		return false
@@ -367,9 +642,10 @@ func (r *Reporter) parseResult(result interface{}) (runnerv1.Result, bool) {
 
 var cmdRegex = regexp.MustCompile(`^::([^ :]+)( .*)?::(.*)$`)
 
-func (r *Reporter) handleCommand(originalContent, command, parameters, value string) *string {
+func (r *Reporter) handleCommand(originalContent, command, parameters, value string, step int) *string {
 	if r.stopCommandEndToken != "" && command != r.stopCommandEndToken {
-		return &originalContent
+		escaped := escapeMarker(originalContent)
+		return &escaped
 	}
 
 	switch command {
@@ -382,22 +658,16 @@ func (r *Reporter) handleCommand(originalContent, command, parameters, value str
 		}
 		return nil
 
-	case "notice":
-		// Not implemented yet, so just return the original content.
-		return &originalContent
-	case "warning":
-		// Not implemented yet, so just return the original content.
-		return &originalContent
-	case "error":
-		// Not implemented yet, so just return the original content.
+	case "notice", "warning", "error":
+		// The annotations are rendered in a summary at the end of the log, the line is kept where it has been written.
+		r.annotations.add(parseAnnotation(command, step, parameters, value))
 		return &originalContent
 	case "group":
-		// Returning the original content, because I think the frontend
-		// will use it when rendering the output.
-		return &originalContent
+		// The frontend folds the rows between the markers, which are written consistently by the reporter.
+		marker := r.openGroup(step, value)
+		return &marker
 	case "endgroup":
-		// Ditto
-		return &originalContent
+		return r.closeGroup(step)
 	case "stop-commands":
 		r.stopCommandEndToken = value
 		return nil
@@ -413,11 +683,17 @@ func (r *Reporter) parseLogRow(entry *log.Entry) *runnerv1.LogRow {
 
 	matches := cmdRegex.FindStringSubmatch(content)
 	if matches != nil {
-		if output := r.handleCommand(content, matches[1], matches[2], matches[3]); output != nil {
+		step := -1
+		if v, ok := entry.Data["stepNumber"].(int); ok && entry.Data["stage"] == "Main" {
+			step = v
+		}
+		if output := r.handleCommand(content, matches[1], matches[2], matches[3], step); output != nil {
 			content = *output
 		} else {
 			return nil
 		}
+	} else {
+		content = escapeMarker(content)
 	}
 
 	content = r.logReplacer.Replace(content)
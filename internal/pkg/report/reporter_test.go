@@ -5,8 +5,11 @@ package report
 
 import (
 	"context"
+	"errors"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
 	connect_go "connectrpc.com/connect"
@@ -195,3 +198,126 @@ func TestReporter_Fire(t *testing.T) {
 		assert.Equal(t, int64(3), reporter.state.Steps[0].LogLength)
 	})
 }
+
+func TestReporter_ReportLog(t *testing.T) {
+	var requests []*runnerv1.UpdateLogRequest
+	client := mocks.NewClient(t)
+	client.On("UpdateLog", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateLogRequest]) (*connect_go.Response[runnerv1.UpdateLogResponse], error) {
+		requests = append(requests, req.Msg)
+		return connect_go.NewResponse(&runnerv1.UpdateLogResponse{
+			AckIndex: req.Msg.Index + int64(len(req.Msg.Rows)),
+		}), nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	taskCtx, err := structpb.NewStruct(map[string]interface{}{})
+	require.NoError(t, err)
+	reporter := NewReporter(ctx, cancel, client, &runnerv1.Task{Context: taskCtx})
+	reporter.maxBatchRows = 3
+	reporter.maxBatchBytes = 10
+
+	for _, content := range []string{"a", "b", "c", "d", "0123456789", "e", "f"} {
+		reporter.Logf("%s", content)
+	}
+	for i := 0; i < 3*maxBatchesPerReport; i++ {
+		reporter.Logf("g")
+	}
+
+	require.NoError(t, reporter.ReportLog(false))
+	require.Len(t, requests, maxBatchesPerReport)
+	var batches [][]string
+	for _, req := range requests[:3] {
+		var batch []string
+		for _, row := range req.GetRows() {
+			batch = append(batch, row.Content)
+		}
+		batches = append(batches, batch)
+	}
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"d"}, {"0123456789"}}, batches)
+	assert.EqualValues(t, 3, requests[1].Index)
+	assert.False(t, requests[maxBatchesPerReport-1].NoMore)
+
+	requests = nil
+	require.NoError(t, reporter.ReportLog(true))
+	require.NotEmpty(t, requests)
+	assert.True(t, requests[len(requests)-1].NoMore)
+	assert.Empty(t, reporter.logRows)
+	assert.Zero(t, reporter.logBytes)
+}
+
+func TestReporter_Truncate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	taskCtx, err := structpb.NewStruct(map[string]interface{}{})
+	require.NoError(t, err)
+	reporter := NewReporter(ctx, cancel, mocks.NewClient(t), &runnerv1.Task{Context: taskCtx})
+	reporter.maxBufferedRows = 2
+	reporter.ResetSteps(1)
+
+	data := map[string]interface{}{
+		"stage":      "Main",
+		"stepNumber": 0,
+		"raw_output": true,
+	}
+	for _, line := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, reporter.Fire(&log.Entry{Message: line, Data: data}))
+	}
+	// the rows have been sent
+	reporter.logRows = nil
+	reporter.logBytes = 0
+	require.NoError(t, reporter.Fire(&log.Entry{Message: "f", Data: data}))
+
+	assert.Equal(t, int64(5), reporter.state.Steps[0].LogLength)
+	require.Len(t, reporter.logRows, 2)
+	assert.Equal(t, "3 lines of the log have been dropped", reporter.logRows[0].Content)
+	assert.Equal(t, "f", reporter.logRows[1].Content)
+}
+
+func TestReporter_RunDaemon(t *testing.T) {
+	var mu sync.Mutex
+	var rows []string
+	client := mocks.NewClient(t)
+	client.On("UpdateLog", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateLogRequest]) (*connect_go.Response[runnerv1.UpdateLogResponse], error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, row := range req.Msg.Rows {
+			rows = append(rows, row.Content)
+		}
+		return connect_go.NewResponse(&runnerv1.UpdateLogResponse{
+			AckIndex: req.Msg.Index + int64(len(req.Msg.Rows)),
+		}), nil
+	})
+	client.On("UpdateTask", mock.Anything, mock.Anything).Return(connect_go.NewResponse(&runnerv1.UpdateTaskResponse{}), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	taskCtx, err := structpb.NewStruct(map[string]interface{}{})
+	require.NoError(t, err)
+	reporter := NewReporter(ctx, cancel, client, &runnerv1.Task{Context: taskCtx})
+	reporter.maxBatchRows = 3
+	reporter.reportInterval = time.Hour
+
+	reporter.RunDaemon()
+	reporter.Logf("a")
+	reporter.Logf("b")
+	reporter.Logf("c")
+	// the batch is full, so it's sent long before the next periodic report
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return assert.ObjectsAreEqual([]string{"a", "b", "c"}, rows)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReporter_backoff(t *testing.T) {
+	reporter := &Reporter{reportInterval: time.Second, maxReportBackoff: 5 * time.Second}
+	failed := errors.New("unavailable")
+
+	delay := reporter.reportInterval
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		delay = reporter.backoff(delay, failed)
+		delays = append(delays, delay)
+	}
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+	assert.Equal(t, time.Second, reporter.backoff(delay, nil))
+}
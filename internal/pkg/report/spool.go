@@ -0,0 +1,255 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package report
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"connectrpc.com/connect"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"gitea.com/gitea/act_runner/internal/pkg/client"
+)
+
+const (
+	spoolRowsFile  = "rows"
+	spoolStateFile = "state.json"
+
+	// spoolMaxAge is how long a spool is replayed before it's given up, Gitea may refuse it forever.
+	spoolMaxAge = 7 * 24 * time.Hour
+)
+
+// Spool keeps the log rows and the state of a task on disk, until Gitea has received them.
+// The rows file has all the rows of the task since its start, the state file has the acknowledged index of the rows,
+// so they can be sent again by ReplaySpools if the runner restarts or Gitea is unreachable for too long.
+type Spool struct {
+	dir     string
+	rows    *os.File
+	written int // written is the number of rows in the rows file
+}
+
+// OpenSpool creates the spool of task id in dir.
+func OpenSpool(dir string, id int64) (*Spool, error) {
+	dir = filepath.Join(dir, strconv.FormatInt(id, 10))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	rows, err := os.OpenFile(filepath.Join(dir, spoolRowsFile), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Spool{dir: dir, rows: rows}, nil
+}
+
+// AppendRows appends the rows from index on, the ones which have been written already are skipped.
+func (s *Spool) AppendRows(index int, rows []*runnerv1.LogRow) error {
+	if skip := s.written - index; skip > 0 {
+		if skip >= len(rows) {
+			return nil
+		}
+		rows = rows[skip:]
+	} else if skip < 0 {
+		return fmt.Errorf("rows %d to %d are missing in the spool", s.written, index)
+	}
+	w := bufio.NewWriter(s.rows)
+	for _, row := range rows {
+		if _, err := protodelim.MarshalTo(w, row); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	s.written += len(rows)
+	return nil
+}
+
+// spoolState is the content of the state file.
+type spoolState struct {
+	LogIndex int             `json:"log_index"` // LogIndex is the index of the first row which hasn't been acknowledged.
+	Task     json.RawMessage `json:"task"`      // Task is the runnerv1.UpdateTaskRequest to send.
+}
+
+// SaveState replaces the state with logIndex and task.
+func (s *Spool) SaveState(logIndex int, task *runnerv1.UpdateTaskRequest) error {
+	b, err := protojson.Marshal(task)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(&spoolState{LogIndex: logIndex, Task: b})
+	if err != nil {
+		return err
+	}
+	// replace it atomically, the runner may be killed at any time
+	tmp := filepath.Join(s.dir, spoolStateFile+".tmp")
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, spoolStateFile))
+}
+
+// Remove removes the spool, once Gitea has received everything.
+func (s *Spool) Remove() error {
+	return errors.Join(s.rows.Close(), os.RemoveAll(s.dir))
+}
+
+// Close closes the spool but keeps it on disk, to be replayed later.
+func (s *Spool) Close() error {
+	return s.rows.Close()
+}
+
+// ReplaySpools sends the spools in dir to Gitea and removes them, except the ones of the tasks which are running.
+// The tasks which hadn't finished are reported as failed, since the runner stopped running them.
+func ReplaySpools(ctx context.Context, cli client.Client, dir string, running func(id int64) bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("cannot read the spools: %v", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		id, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil || !entry.IsDir() || running(id) {
+			continue
+		}
+		spoolDir := filepath.Join(dir, entry.Name())
+		if err := replaySpool(ctx, cli, id, spoolDir); err != nil {
+			if rejected(err) {
+				log.Warnf("giving up the spool of task %d, Gitea rejects it: %v", id, err)
+				_ = os.RemoveAll(spoolDir)
+				continue
+			}
+			if info, statErr := os.Stat(spoolDir); statErr == nil && time.Since(info.ModTime()) > spoolMaxAge {
+				log.Warnf("giving up the spool of task %d, it can't be replayed for %s: %v", id, spoolMaxAge, err)
+				_ = os.RemoveAll(spoolDir)
+				continue
+			}
+			log.Warnf("cannot replay the spool of task %d: %v", id, err)
+			continue
+		}
+		if err := os.RemoveAll(spoolDir); err != nil {
+			log.Warnf("cannot remove the spool of task %d: %v", id, err)
+		}
+		log.Infof("replayed the spooled logs and state of task %d", id)
+	}
+}
+
+// rejected reports whether Gitea has rejected a spool for good, like the task doesn't exist or has been finished,
+// so replaying it again won't succeed. The other errors, like Gitea being unavailable, may go away.
+func rejected(err error) bool {
+	switch connect.CodeOf(err) {
+	case connect.CodeNotFound, connect.CodeFailedPrecondition, connect.CodeInvalidArgument, connect.CodePermissionDenied:
+		return true
+	}
+	return false
+}
+
+// RunSpoolReplayer replays the spools in dir now and then every interval until ctx is done.
+func RunSpoolReplayer(ctx context.Context, cli client.Client, dir string, running func(id int64) bool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ReplaySpools(ctx, cli, dir, running)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func replaySpool(ctx context.Context, cli client.Client, id int64, dir string) error {
+	state := &spoolState{}
+	task := &runnerv1.UpdateTaskRequest{State: &runnerv1.TaskState{Id: id}}
+	if content, err := os.ReadFile(filepath.Join(dir, spoolStateFile)); err == nil {
+		if err := json.Unmarshal(content, state); err != nil {
+			return err
+		}
+		if err := protojson.Unmarshal(state.Task, task); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	rows, err := readSpoolRows(filepath.Join(dir, spoolRowsFile), state.LogIndex)
+	if err != nil {
+		return err
+	}
+	if task.State.Result == runnerv1.Result_RESULT_UNSPECIFIED {
+		now := timestamppb.Now()
+		rows = append(rows, &runnerv1.LogRow{Time: now, Content: "The runner stopped before the task finished"})
+		for _, step := range task.State.Steps {
+			if step.Result == runnerv1.Result_RESULT_UNSPECIFIED {
+				step.Result = runnerv1.Result_RESULT_CANCELLED
+			}
+		}
+		task.State.Result = runnerv1.Result_RESULT_FAILURE
+		task.State.StoppedAt = now
+	}
+
+	// the rows are sent in batches like the reporter does, the last one tells there are no more
+	index := state.LogIndex
+	for sent := false; !sent || len(rows) > 0; sent = true {
+		batch := batchRows(rows, maxBatchRows, maxBatchBytes)
+		resp, err := cli.UpdateLog(ctx, connect.NewRequest(&runnerv1.UpdateLogRequest{
+			TaskId: id,
+			Index:  int64(index),
+			Rows:   batch,
+			NoMore: len(batch) == len(rows),
+		}))
+		if err != nil {
+			return err
+		}
+		acked := int(resp.Msg.AckIndex) - index
+		if acked < len(batch) {
+			return errors.New("not all logs are submitted")
+		}
+		rows = rows[min(acked, len(rows)):]
+		index += acked
+	}
+	_, err = cli.UpdateTask(ctx, connect.NewRequest(task))
+	return err
+}
+
+// readSpoolRows reads the rows from index on, a row torn by a crash ends the rows.
+func readSpoolRows(file string, index int) ([]*runnerv1.LogRow, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []*runnerv1.LogRow
+	r := bufio.NewReader(f)
+	for i := 0; ; i++ {
+		row := &runnerv1.LogRow{}
+		if err := protodelim.UnmarshalFrom(r, row); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return rows, nil
+			}
+			return nil, err
+		}
+		if i >= index {
+			rows = append(rows, row)
+		}
+	}
+}
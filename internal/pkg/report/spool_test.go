@@ -0,0 +1,189 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package report
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	connect_go "connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"gitea.com/gitea/act_runner/internal/pkg/client/mocks"
+)
+
+func TestReporter_Spool(t *testing.T) {
+	dir := t.TempDir()
+
+	// Gitea acknowledges the first row, then it's unreachable
+	down := false
+	unreachable := mocks.NewClient(t)
+	unreachable.On("UpdateLog", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateLogRequest]) (*connect_go.Response[runnerv1.UpdateLogResponse], error) {
+		if down {
+			return nil, errors.New("unavailable")
+		}
+		return connect_go.NewResponse(&runnerv1.UpdateLogResponse{AckIndex: req.Msg.Index + 1}), nil
+	})
+	unreachable.On("UpdateTask", mock.Anything, mock.Anything).Return(nil, errors.New("unavailable")).Maybe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	taskCtx, err := structpb.NewStruct(map[string]interface{}{})
+	require.NoError(t, err)
+	reporter := NewReporter(ctx, cancel, unreachable, &runnerv1.Task{Id: 42, Context: taskCtx})
+	spool, err := OpenSpool(dir, 42)
+	require.NoError(t, err)
+	reporter.SetSpool(spool)
+	reporter.ResetSteps(1)
+
+	reporter.Logf("first")
+	reporter.Logf("second")
+	require.NoError(t, reporter.ReportLog(false))
+	reporter.SetOutputs(map[string]string{"key": "value"})
+	down = true
+	reporter.Logf("third")
+	cancel()
+	assert.Error(t, reporter.Close(""))
+
+	// the runner is restarted, and Gitea is back
+	var logs []*runnerv1.UpdateLogRequest
+	var tasks []*runnerv1.UpdateTaskRequest
+	client := mocks.NewClient(t)
+	client.On("UpdateLog", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateLogRequest]) (*connect_go.Response[runnerv1.UpdateLogResponse], error) {
+		logs = append(logs, req.Msg)
+		return connect_go.NewResponse(&runnerv1.UpdateLogResponse{AckIndex: req.Msg.Index + int64(len(req.Msg.Rows))}), nil
+	})
+	client.On("UpdateTask", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateTaskRequest]) (*connect_go.Response[runnerv1.UpdateTaskResponse], error) {
+		tasks = append(tasks, req.Msg)
+		return connect_go.NewResponse(&runnerv1.UpdateTaskResponse{}), nil
+	})
+
+	ReplaySpools(context.Background(), client, dir, func(id int64) bool { return id == 42 })
+	assert.Empty(t, logs, "running tasks are skipped")
+
+	ReplaySpools(context.Background(), client, dir, func(int64) bool { return false })
+	require.Len(t, logs, 1)
+	assert.EqualValues(t, 1, logs[0].Index)
+	assert.True(t, logs[0].NoMore)
+	var contents []string
+	for _, row := range logs[0].Rows {
+		contents = append(contents, row.Content)
+	}
+	assert.Equal(t, []string{"second", "third", "Early termination"}, contents)
+
+	require.Len(t, tasks, 1)
+	assert.EqualValues(t, 42, tasks[0].State.Id)
+	assert.Equal(t, runnerv1.Result_RESULT_FAILURE, tasks[0].State.Result)
+	assert.Equal(t, runnerv1.Result_RESULT_CANCELLED, tasks[0].State.Steps[0].Result)
+	assert.Equal(t, map[string]string{"key": "value"}, tasks[0].Outputs)
+
+	_, err = os.Stat(filepath.Join(dir, "42"))
+	assert.True(t, os.IsNotExist(err), "the spool is removed once it has been replayed")
+}
+
+func TestReplaySpools_Unfinished(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir, 7)
+	require.NoError(t, err)
+	require.NoError(t, spool.AppendRows(0, []*runnerv1.LogRow{{Content: "a"}, {Content: "b"}}))
+	require.NoError(t, spool.SaveState(1, &runnerv1.UpdateTaskRequest{State: &runnerv1.TaskState{
+		Id:    7,
+		Steps: []*runnerv1.StepState{{Id: 0, Result: runnerv1.Result_RESULT_SUCCESS}, {Id: 1}},
+	}}))
+	require.NoError(t, spool.Close())
+
+	// the runner was killed while writing a row
+	f, err := os.OpenFile(filepath.Join(dir, "7", spoolRowsFile), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{10, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	var logReq *runnerv1.UpdateLogRequest
+	var task *runnerv1.UpdateTaskRequest
+	client := mocks.NewClient(t)
+	client.On("UpdateLog", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateLogRequest]) (*connect_go.Response[runnerv1.UpdateLogResponse], error) {
+		logReq = req.Msg
+		return connect_go.NewResponse(&runnerv1.UpdateLogResponse{AckIndex: req.Msg.Index + int64(len(req.Msg.Rows))}), nil
+	})
+	client.On("UpdateTask", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateTaskRequest]) (*connect_go.Response[runnerv1.UpdateTaskResponse], error) {
+		task = req.Msg
+		return connect_go.NewResponse(&runnerv1.UpdateTaskResponse{}), nil
+	})
+	ReplaySpools(context.Background(), client, dir, func(int64) bool { return false })
+
+	require.NotNil(t, logReq)
+	require.Len(t, logReq.Rows, 2)
+	assert.Equal(t, "b", logReq.Rows[0].Content)
+	assert.Equal(t, "The runner stopped before the task finished", logReq.Rows[1].Content)
+	require.NotNil(t, task)
+	assert.Equal(t, runnerv1.Result_RESULT_FAILURE, task.State.Result)
+	assert.NotNil(t, task.State.StoppedAt)
+	assert.Equal(t, runnerv1.Result_RESULT_SUCCESS, task.State.Steps[0].Result)
+	assert.Equal(t, runnerv1.Result_RESULT_CANCELLED, task.State.Steps[1].Result)
+}
+
+func TestReplaySpools_Rejected(t *testing.T) {
+	dir := t.TempDir()
+	for _, id := range []int64{1, 2, 3} {
+		spool, err := OpenSpool(dir, id)
+		require.NoError(t, err)
+		require.NoError(t, spool.Close())
+	}
+
+	client := mocks.NewClient(t)
+	client.On("UpdateLog", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateLogRequest]) (*connect_go.Response[runnerv1.UpdateLogResponse], error) {
+		switch req.Msg.TaskId {
+		case 1:
+			return nil, connect_go.NewError(connect_go.CodeNotFound, errors.New("task not found"))
+		case 2:
+			return nil, connect_go.NewError(connect_go.CodeFailedPrecondition, errors.New("task is done"))
+		}
+		return nil, connect_go.NewError(connect_go.CodeUnavailable, errors.New("unavailable"))
+	})
+	ReplaySpools(context.Background(), client, dir, func(int64) bool { return false })
+
+	for _, id := range []string{"1", "2"} {
+		_, err := os.Stat(filepath.Join(dir, id))
+		assert.True(t, os.IsNotExist(err), "the spool rejected by Gitea is removed")
+	}
+	_, err := os.Stat(filepath.Join(dir, "3"))
+	assert.NoError(t, err, "the spool is kept while Gitea is unavailable")
+}
+
+func TestReplaySpools_Batches(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir, 8)
+	require.NoError(t, err)
+	rows := make([]*runnerv1.LogRow, maxBatchRows+10)
+	for i := range rows {
+		rows[i] = &runnerv1.LogRow{Content: "row"}
+	}
+	require.NoError(t, spool.AppendRows(0, rows))
+	require.NoError(t, spool.SaveState(5, &runnerv1.UpdateTaskRequest{State: &runnerv1.TaskState{Id: 8, Result: runnerv1.Result_RESULT_SUCCESS}}))
+	require.NoError(t, spool.Close())
+
+	var logs []*runnerv1.UpdateLogRequest
+	client := mocks.NewClient(t)
+	client.On("UpdateLog", mock.Anything, mock.Anything).Return(func(_ context.Context, req *connect_go.Request[runnerv1.UpdateLogRequest]) (*connect_go.Response[runnerv1.UpdateLogResponse], error) {
+		logs = append(logs, req.Msg)
+		return connect_go.NewResponse(&runnerv1.UpdateLogResponse{AckIndex: req.Msg.Index + int64(len(req.Msg.Rows))}), nil
+	})
+	client.On("UpdateTask", mock.Anything, mock.Anything).Return(connect_go.NewResponse(&runnerv1.UpdateTaskResponse{}), nil)
+	ReplaySpools(context.Background(), client, dir, func(int64) bool { return false })
+
+	require.Len(t, logs, 2)
+	assert.EqualValues(t, 5, logs[0].Index)
+	assert.Len(t, logs[0].Rows, maxBatchRows)
+	assert.False(t, logs[0].NoMore)
+	assert.EqualValues(t, 5+maxBatchRows, logs[1].Index)
+	assert.Len(t, logs[1].Rows, 5)
+	assert.True(t, logs[1].NoMore)
+}
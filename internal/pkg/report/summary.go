@@ -0,0 +1,76 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package report
+
+import (
+	"fmt"
+	"strings"
+
+	runnerv1 "code.gitea.io/actions-proto-go/runner/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// MaxSummarySize is the size limit of the summary of a step, like GitHub. Larger summaries are ignored.
+	MaxSummarySize = 1024 * 1024
+	// maxSummaries is the number of summaries of a task rendered, like GitHub.
+	maxSummaries = 20
+)
+
+// stepSummary is the content written by a step to $GITHUB_STEP_SUMMARY.
+type stepSummary struct {
+	step    int
+	content string
+}
+
+// AddSummary adds the summary written by the step with index step, it's rendered at the end of the log,
+// until Gitea can show it.
+func (r *Reporter) AddSummary(step int, summary string) {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	if strings.TrimSpace(summary) == "" {
+		return
+	}
+	if len(r.summaries) == maxSummaries {
+		// only the summaries rendered are kept
+		r.summariesOmitted++
+		return
+	}
+	if len(summary) > MaxSummarySize {
+		summary = fmt.Sprintf("The summary is ignored, since it's larger than %d KiB.", MaxSummarySize/1024)
+	}
+	r.summaries = append(r.summaries, &stepSummary{step: step, content: summary})
+}
+
+// pushSummaries appends the section of the summaries of the steps, it must be called with stateMu held.
+// The lines of the summaries which would be folded by the frontend are escaped, so they can't break the groups of the log.
+// The section is like:
+//
+//	===== Job summary =====
+//	----- Step 2 -----
+//	# Test results
+//	===== End of job summary =====
+func (r *Reporter) pushSummaries() {
+	if len(r.summaries) == 0 {
+		return
+	}
+	now := timestamppb.Now()
+	push := func(line string) {
+		r.appendRow(&runnerv1.LogRow{Time: now, Content: line})
+	}
+
+	push("===== Job summary =====")
+	for _, s := range r.summaries {
+		push(fmt.Sprintf("----- Step %d -----", s.step+1))
+		content := strings.ToValidUTF8(r.logReplacer.Replace(s.content), "?")
+		for _, line := range strings.Split(strings.TrimRight(strings.ReplaceAll(content, "\r\n", "\n"), "\n"), "\n") {
+			push(escapeMarker(line))
+		}
+	}
+	if r.summariesOmitted > 0 {
+		push(fmt.Sprintf("%d more summaries are omitted", r.summariesOmitted))
+	}
+	push("===== End of job summary =====")
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReporter_AddSummary(t *testing.T) {
+	r := &Reporter{
+		logReplacer:      strings.NewReplacer("s3cr3t", "***"),
+		maxBufferedRows:  maxBufferedRows,
+		maxBufferedBytes: maxBufferedBytes,
+	}
+	r.AddSummary(1, "# Tests\r\n\nPassed with token s3cr3t\n::group::Details\n::endgroup::\n")
+	r.AddSummary(2, " \n")
+	r.AddSummary(3, strings.Repeat("a", MaxSummarySize+1))
+	r.pushSummaries()
+
+	var contents []string
+	for _, row := range r.logRows {
+		contents = append(contents, row.Content)
+	}
+	assert.Equal(t, []string{
+		"===== Job summary =====",
+		"----- Step 2 -----",
+		"# Tests",
+		"",
+		"Passed with token ***",
+		" ::group::Details",
+		" ::endgroup::",
+		"----- Step 4 -----",
+		"The summary is ignored, since it's larger than 1024 KiB.",
+		"===== End of job summary =====",
+	}, contents)
+}
+
+func TestReporter_AddSummary_Omitted(t *testing.T) {
+	r := &Reporter{
+		logReplacer:      strings.NewReplacer(),
+		maxBufferedRows:  maxBufferedRows,
+		maxBufferedBytes: maxBufferedBytes,
+	}
+	for i := 0; i < maxSummaries+3; i++ {
+		r.AddSummary(i, "summary")
+	}
+	assert.Len(t, r.summaries, maxSummaries, "the summaries beyond maxSummaries are only counted")
+	r.pushSummaries()
+	assert.Equal(t, "3 more summaries are omitted", r.logRows[len(r.logRows)-2].Content)
+}
@@ -0,0 +1,586 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/ver"
+)
+
+// serviceAccountDir is where Kubernetes mounts the service account of a pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// podPollInterval is the interval to check whether a pod has started or its job has finished.
+var podPollInterval = time.Second
+
+const (
+	podDir    = "/act"     // podDir is the directory of the task in the job container.
+	podJobDir = "/act-job" // podJobDir is where the job is mounted in the job container.
+)
+
+// pod is a Kubernetes pod for a task, its container "job" runs the job and the other containers run the services.
+type pod struct {
+	cfg      config.Kubernetes
+	image    string
+	name     string
+	services []Service
+
+	server    string
+	token     string
+	namespace string
+	client    *http.Client
+	uid       string
+}
+
+func newPod(cfg config.Kubernetes, image, name string, services []Service) (*pod, error) {
+	p := &pod{
+		cfg:       cfg,
+		image:     image,
+		name:      name,
+		services:  services,
+		server:    strings.TrimSuffix(cfg.APIServer, "/"),
+		namespace: cfg.Namespace,
+	}
+
+	inCluster := p.server == ""
+	if inCluster {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("kubernetes.api_server is required out of a cluster")
+		}
+		p.server = "https://" + net.JoinHostPort(host, port)
+	}
+	tokenFile, caFile := cfg.TokenFile, cfg.CAFile
+	if inCluster && tokenFile == "" {
+		tokenFile = serviceAccountDir + "/token"
+	}
+	if inCluster && caFile == "" {
+		caFile = serviceAccountDir + "/ca.crt"
+	}
+	if p.namespace == "" {
+		p.namespace = "default"
+		if inCluster {
+			if content, err := os.ReadFile(serviceAccountDir + "/namespace"); err == nil {
+				p.namespace = strings.TrimSpace(string(content))
+			}
+		}
+	}
+
+	if tokenFile != "" {
+		// the token is read for each task, because the token of a service account is rotated
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		p.token = strings.TrimSpace(string(content))
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		content, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	p.client = &http.Client{Transport: transport}
+	return p, nil
+}
+
+func (p *pod) Dir() string {
+	return podDir
+}
+
+func (p *pod) Start(ctx context.Context) error {
+	// a runner which has been killed may have left the pod of the task
+	if err := p.Destroy(ctx); err != nil {
+		return err
+	}
+	if err := p.waitDeleted(ctx); err != nil {
+		return err
+	}
+
+	manifest, err := p.manifest()
+	if err != nil {
+		return err
+	}
+	created := struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}{}
+	if err := p.do(ctx, http.MethodPost, "pods", manifest, &created); err != nil {
+		return fmt.Errorf("create pod %s: %w", p.name, err)
+	}
+	p.uid = created.Metadata.UID
+	return nil
+}
+
+func (p *pod) Run(ctx context.Context, job []byte, stdout, _ io.Writer) error {
+	// the job contains the secrets of the task, so it's a secret, removed with the pod
+	secret := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":   p.name,
+			"labels": p.labels(),
+			"ownerReferences": []any{map[string]any{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"name":       p.name,
+				"uid":        p.uid,
+			}},
+		},
+		"type": "Opaque",
+		"data": map[string][]byte{"job.json": job},
+	}
+	if err := p.do(ctx, http.MethodPost, "secrets", secret, nil); err != nil {
+		return fmt.Errorf("create secret %s: %w", p.name, err)
+	}
+
+	if err := p.waitStarted(ctx); err != nil {
+		return err
+	}
+
+	// stdout and stderr of the container are mixed in its log, the events are told apart by readSandboxEvents
+	c, err := p.streamLog(ctx, stdout)
+	if err != nil {
+		return err
+	}
+	if code := c.State.Terminated.ExitCode; code != 0 {
+		return fmt.Errorf("the job container exited with code %d: %s", code, c.State.Terminated.Reason)
+	}
+	return nil
+}
+
+// streamLog copies the log of the job container to stdout, and returns its status once it has terminated.
+// The log is followed again if the stream is interrupted, like when the API server restarts,
+// and the lines already copied are skipped by their timestamps.
+func (p *pod) streamLog(ctx context.Context, stdout io.Writer) (*containerStatus, error) {
+	f := &logFollower{out: stdout}
+	for {
+		resource := "pods/" + p.name + "/log?container=job&follow=true&timestamps=true"
+		if !f.last.IsZero() {
+			// sinceTime is truncated to seconds, so the follower skips the lines it has copied in that second
+			resource += "&sinceTime=" + url.QueryEscape(f.last.UTC().Format(time.RFC3339))
+		}
+		resp, err := p.request(ctx, http.MethodGet, resource, nil)
+		if err == nil {
+			err = f.copy(resp.Body)
+			resp.Body.Close()
+		}
+		if f.err != nil {
+			return nil, fmt.Errorf("copy log of pod %s: %w", p.name, f.err)
+		}
+		if isNotFound(err) {
+			return nil, fmt.Errorf("stream log of pod %s: %w", p.name, err)
+		}
+		if err == nil {
+			// the stream also ends before the container if the log is rotated, and the status may be updated a bit later
+			if status, err := p.status(ctx); err == nil {
+				if c := status.container("job"); c != nil && c.State.Terminated != nil {
+					return c, nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stream log of pod %s: %w", p.name, ctx.Err())
+		case <-time.After(podPollInterval):
+		}
+	}
+}
+
+// logFollower copies the lines of the log streams of a container, which are prefixed by their timestamps.
+// A stream following the log since the last line copied starts at the same second, so the lines before it and the ones
+// copied with the same timestamp are skipped.
+type logFollower struct {
+	out  io.Writer
+	err  error     // err is the error writing to out
+	last time.Time // last is the timestamp of the last line copied
+	seen int       // seen counts the lines copied with the timestamp last
+	dups int       // dups counts the lines with the timestamp last in the current stream
+}
+
+// copy copies the lines of stream, a line cut by an interrupted stream is left for the next one.
+func (f *logFollower) copy(stream io.Reader) error {
+	f.dups = 0
+	r := bufio.NewReader(stream)
+	for {
+		line, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// the last line of a container may have no line break
+			if line != "" {
+				f.write(line)
+			}
+			return f.err
+		}
+		if err != nil {
+			return err
+		}
+		if f.write(line); f.err != nil {
+			return f.err
+		}
+	}
+}
+
+func (f *logFollower) write(line string) {
+	timestamp, content, _ := strings.Cut(line, " ")
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		// the line isn't prefixed, so it can't be told whether it has been copied
+		_, f.err = io.WriteString(f.out, line)
+		return
+	}
+	switch {
+	case t.Before(f.last):
+		return
+	case t.Equal(f.last):
+		f.dups++
+		if f.dups <= f.seen {
+			return
+		}
+		f.seen++
+	default:
+		f.last = t
+		f.seen = 1
+		f.dups = 1
+	}
+	_, f.err = io.WriteString(f.out, content)
+}
+
+func (p *pod) Destroy(ctx context.Context) error {
+	var errs []error
+	for _, resource := range []string{"pods/" + p.name + "?gracePeriodSeconds=0", "secrets/" + p.name} {
+		if err := p.do(ctx, http.MethodDelete, resource, nil, nil); err != nil && !isNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// waitDeleted waits for the pod to be gone, since it's deleted in the background and its name can't be reused before.
+func (p *pod) waitDeleted(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.StartTimeout)
+	defer cancel()
+	for {
+		err := p.do(ctx, http.MethodGet, "pods/"+p.name, nil, nil)
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("get pod %s: %w", p.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pod %s is still being deleted after %s: %w", p.name, p.cfg.StartTimeout, ctx.Err())
+		case <-time.After(podPollInterval):
+		}
+	}
+}
+
+// podFailures are the reasons of waiting containers which won't start without a change of the pod.
+var podFailures = []string{"ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError", "CreateContainerError"}
+
+// waitStarted waits for the job container to start, or to fail.
+func (p *pod) waitStarted(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.StartTimeout)
+	defer cancel()
+	for {
+		status, err := p.status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, c := range status.Status.InitContainerStatuses {
+			if t := c.State.Terminated; t != nil && t.ExitCode != 0 {
+				return fmt.Errorf("failed to copy act_runner from %s: %s %s", p.runnerImage(), t.Reason, t.Message)
+			}
+		}
+		for _, c := range append(status.Status.InitContainerStatuses, status.Status.ContainerStatuses...) {
+			if w := c.State.Waiting; w != nil && slices.Contains(podFailures, w.Reason) {
+				return fmt.Errorf("container %s of pod %s can't start: %s %s", c.Name, p.name, w.Reason, w.Message)
+			}
+		}
+		if job := status.container("job"); job != nil && (job.State.Running != nil || job.State.Terminated != nil) {
+			return nil
+		}
+		if status.Status.Phase == "Failed" {
+			return fmt.Errorf("pod %s failed: %s %s", p.name, status.Status.Reason, status.Status.Message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pod %s hasn't started after %s: %w", p.name, p.cfg.StartTimeout, ctx.Err())
+		case <-time.After(podPollInterval):
+		}
+	}
+}
+
+type podStatus struct {
+	Status struct {
+		Phase                 string            `json:"phase"`
+		Reason                string            `json:"reason"`
+		Message               string            `json:"message"`
+		InitContainerStatuses []containerStatus `json:"initContainerStatuses"`
+		ContainerStatuses     []containerStatus `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+type containerStatus struct {
+	Name  string `json:"name"`
+	State struct {
+		Waiting *struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"waiting"`
+		Running    *struct{} `json:"running"`
+		Terminated *struct {
+			ExitCode int    `json:"exitCode"`
+			Reason   string `json:"reason"`
+			Message  string `json:"message"`
+		} `json:"terminated"`
+	} `json:"state"`
+}
+
+func (s *podStatus) container(name string) *containerStatus {
+	for i, c := range s.Status.ContainerStatuses {
+		if c.Name == name {
+			return &s.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+func (p *pod) status(ctx context.Context) (*podStatus, error) {
+	status := &podStatus{}
+	if err := p.do(ctx, http.MethodGet, "pods/"+p.name, nil, status); err != nil {
+		return nil, fmt.Errorf("get pod %s: %w", p.name, err)
+	}
+	return status, nil
+}
+
+func (p *pod) labels() map[string]any {
+	return map[string]any{
+		"app.kubernetes.io/managed-by": "act_runner",
+		"app.kubernetes.io/name":       p.name,
+	}
+}
+
+// runnerImage returns the image which act_runner is copied from.
+func (p *pod) runnerImage() string {
+	if p.cfg.RunnerImage != "" {
+		return p.cfg.RunnerImage
+	}
+	if v := ver.Version(); v != "dev" {
+		return "gitea/act_runner:" + strings.TrimPrefix(v, "v")
+	}
+	return "gitea/act_runner:nightly"
+}
+
+// manifest returns the pod built from the template.
+func (p *pod) manifest() (map[string]any, error) {
+	obj := map[string]any{}
+	if p.cfg.PodTemplate != "" {
+		content, err := os.ReadFile(p.cfg.PodTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, &obj); err != nil {
+			return nil, fmt.Errorf("parse pod template %q: %w", p.cfg.PodTemplate, err)
+		}
+		if obj == nil {
+			obj = map[string]any{}
+		}
+	}
+	obj["apiVersion"] = "v1"
+	obj["kind"] = "Pod"
+
+	metadata := mapField(obj, "metadata")
+	delete(metadata, "generateName")
+	metadata["name"] = p.name
+	metadata["namespace"] = p.namespace
+	labels := mapField(metadata, "labels")
+	for k, v := range p.labels() {
+		labels[k] = v
+	}
+
+	spec := mapField(obj, "spec")
+	spec["restartPolicy"] = "Never"
+	spec["volumes"] = append(listField(spec, "volumes"),
+		map[string]any{"name": "act-runner", "emptyDir": map[string]any{}},
+		map[string]any{"name": "act-runner-job", "secret": map[string]any{"secretName": p.name}},
+	)
+	spec["initContainers"] = append(listField(spec, "initContainers"), map[string]any{
+		"name":         "act-runner",
+		"image":        p.runnerImage(),
+		"command":      []any{"cp", "/usr/local/bin/act_runner", podDir + "/act_runner"},
+		"volumeMounts": []any{map[string]any{"name": "act-runner", "mountPath": podDir}},
+	})
+
+	// the container "job" of the template is the base of the job container
+	containers := listField(spec, "containers")
+	job := map[string]any{"name": "job"}
+	if i := slices.IndexFunc(containers, func(c any) bool {
+		m, ok := c.(map[string]any)
+		return ok && m["name"] == "job"
+	}); i >= 0 {
+		job = containers[i].(map[string]any)
+	} else {
+		containers = append([]any{job}, containers...)
+	}
+	job["image"] = p.image
+	job["command"] = []any{podDir + "/act_runner", WorkerCommand, "--job", podJobDir + "/job.json"}
+	delete(job, "args")
+	job["volumeMounts"] = append(listField(job, "volumeMounts"),
+		map[string]any{"name": "act-runner", "mountPath": podDir},
+		map[string]any{"name": "act-runner-job", "mountPath": podJobDir, "readOnly": true},
+	)
+
+	var hostnames []any
+	for _, s := range p.services {
+		c := map[string]any{
+			"name":  "service-" + containerName(s.Name),
+			"image": s.Image,
+		}
+		if len(s.Env) > 0 {
+			env := make([]any, 0, len(s.Env))
+			for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+				env = append(env, map[string]any{"name": k, "value": s.Env[k]})
+			}
+			c["env"] = env
+		}
+		if len(s.Cmd) > 0 {
+			c["args"] = s.Cmd
+		}
+		containers = append(containers, c)
+		hostnames = append(hostnames, s.Name)
+	}
+	spec["containers"] = containers
+	if len(hostnames) > 0 {
+		// the containers of a pod share the network, so the services are reached by their names like with docker
+		spec["hostAliases"] = append(listField(spec, "hostAliases"), map[string]any{"ip": "127.0.0.1", "hostnames": hostnames})
+	}
+	return obj, nil
+}
+
+func mapField(m map[string]any, key string) map[string]any {
+	v, ok := m[key].(map[string]any)
+	if !ok {
+		v = map[string]any{}
+		m[key] = v
+	}
+	return v
+}
+
+func listField(m map[string]any, key string) []any {
+	v, _ := m[key].([]any)
+	return v
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// containerName returns name as a valid name of a container.
+func containerName(name string) string {
+	name = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(name) > 54 {
+		// "service-" is prepended, and a name has 63 characters at most
+		name = strings.TrimRight(name[:54], "-")
+	}
+	return name
+}
+
+// apiError is an error returned by the API server.
+type apiError struct {
+	Code    int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// do sends body as JSON to the resource of the namespace, and decodes the response into out if it's not nil.
+func (p *pod) do(ctx context.Context, method, resource string, body, out any) error {
+	resp, err := p.request(ctx, method, resource, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// request sends the request, the response has a successful status if there is no error.
+func (p *pod) request(ctx context.Context, method, resource string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(content)
+	}
+	u := p.server + "/api/v1/namespaces/" + url.PathEscape(p.namespace) + "/" + resource
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		status := struct {
+			Message string `json:"message"`
+		}{}
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(content, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(content))
+		}
+		return nil, &apiError{Code: resp.StatusCode, Message: status.Message}
+	}
+	return resp, nil
+}
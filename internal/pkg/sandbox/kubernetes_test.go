@@ -0,0 +1,403 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+// fakeAPIServer serves the pods and the secrets of a namespace, the job container of a pod runs until its log is read.
+type fakeAPIServer struct {
+	mu       sync.Mutex
+	requests []string
+	pods     map[string]map[string]any
+	secrets  map[string]map[string]any
+	started  map[string]bool // started reports whether the job container of a pod is running
+	finished map[string]bool // finished reports whether the job container of a pod has terminated
+	log      []logLine
+	drop     int            // drop is the number of lines after which the next log stream is interrupted, if it's not 0
+	waiting  string         // waiting is the reason the job container waits for, instead of running
+	lingers  int            // lingers is the number of times a deleted pod is still found, before it's gone
+	deleted  map[string]int // deleted is the number of times each pod deleted in the background is still found
+}
+
+// logLine is a line of the log of a job container.
+type logLine struct {
+	time    time.Time
+	content string
+}
+
+// logLines returns lines logged at the same time.
+func logLines(content string) []logLine {
+	var lines []logLine
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line != "" {
+			lines = append(lines, logLine{time: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC), content: line})
+		}
+	}
+	return lines
+}
+
+func newFakeAPIServer(t *testing.T) (*fakeAPIServer, *httptest.Server) {
+	f := &fakeAPIServer{
+		pods:     map[string]map[string]any{},
+		secrets:  map[string]map[string]any{},
+		started:  map[string]bool{},
+		finished: map[string]bool{},
+		deleted:  map[string]int{},
+	}
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	return f, s
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+
+	if r.Header.Get("Authorization") != "Bearer secret-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"kind":"Status","message":"Unauthorized"}`))
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/namespaces/ci/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.Split(path, "/")
+	store := f.pods
+	if parts[0] == "secrets" {
+		store = f.secrets
+	}
+
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprintf(w, `{"kind":"Status","message":"%s not found"}`, path)
+	}
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1:
+		obj := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metadata := obj["metadata"].(map[string]any)
+		if _, ok := store[metadata["name"].(string)]; ok {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = fmt.Fprintf(w, `{"kind":"Status","message":"%s already exists"}`, metadata["name"])
+			return
+		}
+		metadata["uid"] = "uid-" + metadata["name"].(string)
+		store[metadata["name"].(string)] = obj
+		_ = json.NewEncoder(w).Encode(obj)
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		if _, ok := store[parts[1]]; !ok {
+			notFound()
+			return
+		}
+		if parts[0] == "pods" && f.lingers > 0 {
+			// the pod is deleted in the background
+			if _, ok := f.deleted[parts[1]]; !ok {
+				f.deleted[parts[1]] = f.lingers
+			}
+		} else {
+			delete(store, parts[1])
+		}
+		_, _ = w.Write([]byte(`{}`))
+	case r.Method == http.MethodGet && len(parts) == 2:
+		if n, ok := f.deleted[parts[1]]; ok && parts[0] == "pods" {
+			if n == 0 {
+				delete(store, parts[1])
+				delete(f.deleted, parts[1])
+			} else {
+				f.deleted[parts[1]] = n - 1
+			}
+		}
+		if _, ok := store[parts[1]]; !ok {
+			notFound()
+			return
+		}
+		state := map[string]any{"waiting": map[string]any{"reason": "ContainerCreating"}}
+		switch {
+		case f.finished[parts[1]]:
+			state = map[string]any{"terminated": map[string]any{"exitCode": 0, "reason": "Completed"}}
+		case f.waiting != "":
+			state = map[string]any{"waiting": map[string]any{"reason": f.waiting, "message": "back-off pulling image"}}
+		case f.started[parts[1]]:
+			state = map[string]any{"running": map[string]any{}}
+		}
+		// the job container starts after the first check
+		f.started[parts[1]] = true
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status": map[string]any{
+				"phase":             "Pending",
+				"containerStatuses": []any{map[string]any{"name": "job", "state": state}},
+			},
+		})
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[2] == "log":
+		if _, ok := f.pods[parts[1]]; !ok {
+			notFound()
+			return
+		}
+		var since time.Time
+		if v := r.URL.Query().Get("sinceTime"); v != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var written int
+		for _, line := range f.log {
+			if line.time.Before(since) {
+				continue
+			}
+			if f.drop > 0 && written == f.drop {
+				// the connection is lost in the middle of a line
+				_, _ = io.WriteString(w, line.time.Format(time.RFC3339Nano)+" "+line.content[:1])
+				w.(http.Flusher).Flush()
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				f.drop = 0
+				return
+			}
+			_, _ = io.WriteString(w, line.time.Format(time.RFC3339Nano)+" "+line.content)
+			written++
+		}
+		f.finished[parts[1]] = true
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestPod(t *testing.T) {
+	podPollInterval = time.Millisecond
+	f, server := newFakeAPIServer(t)
+	f.log = logLines("{\"message\":\"event\"}\nworker log\n")
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token\n"), 0o600))
+	template := filepath.Join(dir, "pod.yaml")
+	require.NoError(t, os.WriteFile(template, []byte(`
+metadata:
+  labels:
+    team: ci
+spec:
+  nodeSelector:
+    pool: ci
+  containers:
+    - name: job
+      args: ["ignored"]
+      resources:
+        limits:
+          memory: 4Gi
+`), 0o600))
+
+	p, err := newPod(config.Kubernetes{
+		APIServer:    server.URL,
+		TokenFile:    tokenFile,
+		Namespace:    "ci",
+		PodTemplate:  template,
+		RunnerImage:  "gitea/act_runner:0.2.11",
+		StartTimeout: time.Minute,
+	}, "node:18", "gitea-actions-task-42", []Service{
+		{Name: "postgres", Image: "postgres:16", Env: map[string]string{"POSTGRES_USER": "ci", "POSTGRES_DB": "test"}},
+		{Name: "Redis_Cache", Image: "redis:7", Cmd: []string{"--port", "6380"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/act", p.Dir())
+
+	ctx := context.Background()
+	require.NoError(t, p.Start(ctx))
+	var stdout bytes.Buffer
+	require.NoError(t, p.Run(ctx, []byte(`{"task":{}}`), &stdout, io.Discard))
+	assert.Equal(t, "{\"message\":\"event\"}\nworker log\n", stdout.String())
+
+	manifest, err := json.Marshal(f.pods["gitea-actions-task-42"])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"apiVersion": "v1",
+		"kind": "Pod",
+		"metadata": {
+			"name": "gitea-actions-task-42",
+			"namespace": "ci",
+			"uid": "uid-gitea-actions-task-42",
+			"labels": {
+				"team": "ci",
+				"app.kubernetes.io/managed-by": "act_runner",
+				"app.kubernetes.io/name": "gitea-actions-task-42"
+			}
+		},
+		"spec": {
+			"restartPolicy": "Never",
+			"nodeSelector": {"pool": "ci"},
+			"volumes": [
+				{"name": "act-runner", "emptyDir": {}},
+				{"name": "act-runner-job", "secret": {"secretName": "gitea-actions-task-42"}}
+			],
+			"initContainers": [{
+				"name": "act-runner",
+				"image": "gitea/act_runner:0.2.11",
+				"command": ["cp", "/usr/local/bin/act_runner", "/act/act_runner"],
+				"volumeMounts": [{"name": "act-runner", "mountPath": "/act"}]
+			}],
+			"containers": [
+				{
+					"name": "job",
+					"image": "node:18",
+					"command": ["/act/act_runner", "sandbox-worker", "--job", "/act-job/job.json"],
+					"resources": {"limits": {"memory": "4Gi"}},
+					"volumeMounts": [
+						{"name": "act-runner", "mountPath": "/act"},
+						{"name": "act-runner-job", "mountPath": "/act-job", "readOnly": true}
+					]
+				},
+				{
+					"name": "service-postgres",
+					"image": "postgres:16",
+					"env": [{"name": "POSTGRES_DB", "value": "test"}, {"name": "POSTGRES_USER", "value": "ci"}]
+				},
+				{
+					"name": "service-redis-cache",
+					"image": "redis:7",
+					"args": ["--port", "6380"]
+				}
+			],
+			"hostAliases": [{"ip": "127.0.0.1", "hostnames": ["postgres", "Redis_Cache"]}]
+		}
+	}`, string(manifest))
+
+	secret := f.secrets["gitea-actions-task-42"]
+	require.NotNil(t, secret)
+	assert.Equal(t, map[string]any{"job.json": "eyJ0YXNrIjp7fX0="}, secret["data"])
+	owner := secret["metadata"].(map[string]any)["ownerReferences"].([]any)[0].(map[string]any)
+	assert.Equal(t, "uid-gitea-actions-task-42", owner["uid"])
+
+	require.NoError(t, p.Destroy(ctx))
+	assert.Empty(t, f.pods)
+	assert.Empty(t, f.secrets)
+	assert.Equal(t, []string{
+		"DELETE /api/v1/namespaces/ci/pods/gitea-actions-task-42?gracePeriodSeconds=0",
+		"DELETE /api/v1/namespaces/ci/secrets/gitea-actions-task-42",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-42",
+		"POST /api/v1/namespaces/ci/pods",
+		"POST /api/v1/namespaces/ci/secrets",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-42",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-42",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-42/log?container=job&follow=true&timestamps=true",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-42",
+		"DELETE /api/v1/namespaces/ci/pods/gitea-actions-task-42?gracePeriodSeconds=0",
+		"DELETE /api/v1/namespaces/ci/secrets/gitea-actions-task-42",
+	}, f.requests)
+}
+
+func TestPod_LogInterrupted(t *testing.T) {
+	podPollInterval = time.Millisecond
+	f, server := newFakeAPIServer(t)
+	at := func(sec, nsec int) time.Time { return time.Date(2024, 1, 2, 3, 4, sec, nsec, time.UTC) }
+	f.log = []logLine{
+		{at(5, 100), "first\n"},
+		{at(5, 200), "second\n"},
+		{at(5, 200), "third\n"},
+		{at(6, 300), "fourth\n"},
+		{at(7, 0), "last"},
+	}
+	f.drop = 2
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token"), 0o600))
+	p, err := newPod(config.Kubernetes{
+		APIServer:    server.URL,
+		TokenFile:    tokenFile,
+		Namespace:    "ci",
+		StartTimeout: time.Minute,
+	}, "node:18", "gitea-actions-task-44", nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, p.Start(ctx))
+	var stdout bytes.Buffer
+	require.NoError(t, p.Run(ctx, []byte(`{}`), &stdout, io.Discard))
+	assert.Equal(t, "first\nsecond\nthird\nfourth\nlast", stdout.String(), "each line is copied once")
+
+	var logRequests []string
+	for _, r := range f.requests {
+		if strings.Contains(r, "/log?") {
+			logRequests = append(logRequests, r)
+		}
+	}
+	assert.Equal(t, []string{
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-44/log?container=job&follow=true&timestamps=true",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-44/log?container=job&follow=true&timestamps=true&sinceTime=2024-01-02T03%3A04%3A05Z",
+	}, logRequests)
+}
+
+func TestPod_ImagePullFailure(t *testing.T) {
+	podPollInterval = time.Millisecond
+	f, server := newFakeAPIServer(t)
+	f.waiting = "ImagePullBackOff"
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token"), 0o600))
+	p, err := newPod(config.Kubernetes{
+		APIServer:    server.URL,
+		TokenFile:    tokenFile,
+		Namespace:    "ci",
+		StartTimeout: time.Minute,
+	}, "no-such-image", "gitea-actions-task-43", nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, p.Start(ctx))
+	assert.ErrorContains(t, p.Run(ctx, []byte(`{}`), io.Discard, io.Discard), "container job of pod gitea-actions-task-43 can't start: ImagePullBackOff")
+	require.NoError(t, p.Destroy(ctx))
+}
+
+func TestPod_StartAfterLeftover(t *testing.T) {
+	podPollInterval = time.Millisecond
+	f, server := newFakeAPIServer(t)
+	f.lingers = 2
+	f.pods["gitea-actions-task-45"] = map[string]any{"metadata": map[string]any{"name": "gitea-actions-task-45", "uid": "uid-leftover"}}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token"), 0o600))
+	p, err := newPod(config.Kubernetes{
+		APIServer:    server.URL,
+		TokenFile:    tokenFile,
+		Namespace:    "ci",
+		StartTimeout: time.Minute,
+	}, "node:18", "gitea-actions-task-45", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Start(context.Background()))
+	assert.Equal(t, "uid-gitea-actions-task-45", p.uid)
+	assert.Equal(t, []string{
+		"DELETE /api/v1/namespaces/ci/pods/gitea-actions-task-45?gracePeriodSeconds=0",
+		"DELETE /api/v1/namespaces/ci/secrets/gitea-actions-task-45",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-45",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-45",
+		"GET /api/v1/namespaces/ci/pods/gitea-actions-task-45",
+		"POST /api/v1/namespaces/ci/pods",
+	}, f.requests)
+}
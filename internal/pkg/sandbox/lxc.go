@@ -0,0 +1,108 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+// lxcReadyInterval is the interval to check whether a container has booted.
+const lxcReadyInterval = time.Second
+
+// lxc is an ephemeral clone of an LXC or Incus instance, managed with the incus or lxc client.
+type lxc struct {
+	cfg    config.LXC
+	source string // source is the cloned instance or snapshot, like "template" or "template/snap0"
+	name   string
+}
+
+func newLXC(cfg config.LXC, source, name string) *lxc {
+	return &lxc{
+		cfg:    cfg,
+		source: source,
+		name:   name,
+	}
+}
+
+func (c *lxc) Dir() string {
+	return "/tmp/" + c.name
+}
+
+func (c *lxc) Start(ctx context.Context) error {
+	args := []string{"copy", c.source, c.name, "--ephemeral"}
+	if len(c.cfg.Profiles) > 0 {
+		args = append(args, "--no-profiles")
+		for _, p := range c.cfg.Profiles {
+			args = append(args, "--profile", p)
+		}
+	}
+	if err := c.run(ctx, nil, nil, nil, args...); err != nil {
+		return err
+	}
+	if err := c.run(ctx, nil, nil, nil, "start", c.name); err != nil {
+		return err
+	}
+
+	// the agent answers exec once the container is up, then wait for systemd to finish booting if there is
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.StartTimeout)
+	defer cancel()
+	for {
+		err := c.Exec(ctx, []string{"sh", "-c", "if command -v systemctl >/dev/null; then systemctl is-system-running --wait >/dev/null; fi; true"}, nil, nil, nil)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("container %s isn't ready after %s: %w", c.name, c.cfg.StartTimeout, err)
+		case <-time.After(lxcReadyInterval):
+		}
+	}
+}
+
+func (c *lxc) CopyFile(ctx context.Context, src, dst string, mode os.FileMode) error {
+	return c.run(ctx, nil, nil, nil, "file", "push", "--create-dirs", "--mode", fmt.Sprintf("%04o", mode.Perm()), src, c.name+dst)
+}
+
+func (c *lxc) Exec(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return c.run(ctx, stdin, stdout, stderr, append([]string{"exec", c.name, "--"}, args...)...)
+}
+
+func (c *lxc) Run(ctx context.Context, job []byte, stdout, stderr io.Writer) error {
+	return runCopy(ctx, c, job, stdout, stderr)
+}
+
+func (c *lxc) Destroy(ctx context.Context) error {
+	return c.run(ctx, nil, nil, nil, "delete", "--force", c.name)
+}
+
+// run runs the client with args, its output is returned in the error if stdout or stderr is nil.
+func (c *lxc) run(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.cfg.Command, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if stdout == nil {
+		cmd.Stdout = &output
+	}
+	if stderr == nil {
+		cmd.Stderr = &output
+	}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("%s %s: %w: %s", c.cfg.Command, args[0], err, msg)
+		}
+		return fmt.Errorf("%s %s: %w", c.cfg.Command, args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+// fakeClient writes a client which records its arguments and echoes stdin for exec, it returns the paths of the client and the record.
+func fakeClient(t *testing.T) (string, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake client is a shell script")
+	}
+	dir := t.TempDir()
+	record := filepath.Join(dir, "record")
+	client := filepath.Join(dir, "incus")
+	script := "#!/bin/sh\necho \"$@\" >> " + record + "\nif [ \"$1\" = exec ]; then cat; fi\n"
+	require.NoError(t, os.WriteFile(client, []byte(script), 0o755))
+	return client, record
+}
+
+func TestLXC(t *testing.T) {
+	client, record := fakeClient(t)
+	c := newLXC(config.LXC{
+		Command:      client,
+		Profiles:     []string{"default", "nesting"},
+		StartTimeout: time.Minute,
+	}, "template/snap0", "gitea-actions-task-1")
+
+	ctx := context.Background()
+	require.NoError(t, c.Start(ctx))
+	assert.Equal(t, "/tmp/gitea-actions-task-1", c.Dir())
+	require.NoError(t, c.CopyFile(ctx, "/usr/bin/act_runner", c.Dir()+"/act_runner", 0o755))
+	var stdout bytes.Buffer
+	require.NoError(t, c.Exec(ctx, []string{c.Dir() + "/act_runner", "sandbox-worker"}, strings.NewReader("job"), &stdout, nil))
+	assert.Equal(t, "job", stdout.String())
+	require.NoError(t, c.Destroy(ctx))
+
+	content, err := os.ReadFile(record)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 6)
+	assert.Equal(t, "copy template/snap0 gitea-actions-task-1 --ephemeral --no-profiles --profile default --profile nesting", lines[0])
+	assert.Equal(t, "start gitea-actions-task-1", lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], "exec gitea-actions-task-1 -- sh -c"))
+	assert.Equal(t, "file push --create-dirs --mode 0755 /usr/bin/act_runner gitea-actions-task-1/tmp/gitea-actions-task-1/act_runner", lines[3])
+	assert.Equal(t, "exec gitea-actions-task-1 -- /tmp/gitea-actions-task-1/act_runner sandbox-worker", lines[4])
+	assert.Equal(t, "delete --force gitea-actions-task-1", lines[5])
+}
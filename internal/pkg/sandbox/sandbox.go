@@ -0,0 +1,114 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package sandbox provides environments created for a single task, like system containers or directories on remote hosts.
+// The runner copies itself into a sandbox and runs the job there on the host of the sandbox.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+	"gitea.com/gitea/act_runner/internal/pkg/labels"
+)
+
+// WorkerCommand is the command of the runner which runs a job in a sandbox.
+const WorkerCommand = "sandbox-worker"
+
+// Sandbox is an environment created for a single task.
+type Sandbox interface {
+	// Dir returns the directory of the task in the sandbox, it's removed by Destroy.
+	Dir() string
+	// Start creates the sandbox and waits for it to be ready.
+	Start(ctx context.Context) error
+	// Run runs the runner in the sandbox with WorkerCommand and job as its stdin, and waits for it to exit.
+	Run(ctx context.Context, job []byte, stdout, stderr io.Writer) error
+	// Destroy removes the sandbox and everything in it, it's called even if Start has failed.
+	Destroy(ctx context.Context) error
+}
+
+// Service is a service container of a job.
+type Service struct {
+	Name  string
+	Image string
+	Env   map[string]string
+	Cmd   []string
+}
+
+// New returns the sandbox for a task with a label whose Sandboxed reports true, name identifies the task.
+// Only the sandboxes of labels.SchemeK8s run services.
+func New(label *labels.Label, cfg *config.Config, name string, services []Service) (Sandbox, error) {
+	switch label.Schema {
+	case labels.SchemeLXC:
+		return newLXC(cfg.LXC, strings.TrimPrefix(label.Arg, "//"), name), nil
+	case labels.SchemeSSH:
+		return newRemoteHost(cfg.SSH, label.Arg, name)
+	case labels.SchemeK8s:
+		return newPod(cfg.Kubernetes, strings.TrimPrefix(label.Arg, "//"), name, services)
+	default:
+		return nil, fmt.Errorf("label %s doesn't run in a sandbox", label.Name)
+	}
+}
+
+// copyHost is a sandbox which the runner can be copied into to execute it.
+type copyHost interface {
+	Dir() string
+	// CopyFile copies the local file src to the absolute path dst in the sandbox.
+	CopyFile(ctx context.Context, src, dst string, mode os.FileMode) error
+	// Exec runs args in the sandbox and waits for them to exit.
+	Exec(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// runCopy copies the runner into the directory of the task in h, and runs it with WorkerCommand.
+func runCopy(ctx context.Context, h copyHost, job []byte, stdout, stderr io.Writer) error {
+	if err := checkPlatform(ctx, h); err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	worker := path.Join(h.Dir(), "act_runner")
+	if err := h.CopyFile(ctx, self, worker, 0o755); err != nil {
+		return fmt.Errorf("failed to copy the runner into the sandbox: %w", err)
+	}
+	return h.Exec(ctx, []string{worker, WorkerCommand}, bytes.NewReader(job), stdout, stderr)
+}
+
+// unameMachines maps the machines printed by uname to the architectures of Go, the other ones are the same.
+var unameMachines = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"i686":    "386",
+	"armv6l":  "arm",
+	"armv7l":  "arm",
+}
+
+// checkPlatform returns an error if the OS or the architecture of h isn't the one the runner is built for,
+// since the copy of the runner couldn't be executed there.
+func checkPlatform(ctx context.Context, h copyHost) error {
+	var out bytes.Buffer
+	if err := h.Exec(ctx, []string{"uname", "-sm"}, nil, &out, nil); err != nil {
+		return fmt.Errorf("cannot tell the platform of the sandbox: %w", err)
+	}
+	fields := strings.Fields(out.String())
+	if len(fields) != 2 {
+		return fmt.Errorf("cannot tell the platform of the sandbox from the output of uname %q", out.String())
+	}
+	goos, goarch := strings.ToLower(fields[0]), fields[1]
+	if arch, ok := unameMachines[goarch]; ok {
+		goarch = arch
+	}
+	if goos != runtime.GOOS || goarch != runtime.GOARCH {
+		return fmt.Errorf("the sandbox runs on %s/%s, but the runner is built for %s/%s", goos, goarch, runtime.GOOS, runtime.GOARCH)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCopyHost answers uname with uname, and records the files copied and the commands run.
+type fakeCopyHost struct {
+	uname  string
+	copied []string
+	execs  []string
+}
+
+func (h *fakeCopyHost) Dir() string { return "/tmp/task" }
+
+func (h *fakeCopyHost) CopyFile(_ context.Context, _, dst string, _ os.FileMode) error {
+	h.copied = append(h.copied, dst)
+	return nil
+}
+
+func (h *fakeCopyHost) Exec(_ context.Context, args []string, _ io.Reader, stdout, _ io.Writer) error {
+	h.execs = append(h.execs, strings.Join(args, " "))
+	if args[0] == "uname" {
+		_, err := io.WriteString(stdout, h.uname)
+		return err
+	}
+	return nil
+}
+
+func Test_runCopy(t *testing.T) {
+	machine := runtime.GOARCH
+	if runtime.GOARCH == "amd64" {
+		machine = "x86_64"
+	}
+	h := &fakeCopyHost{uname: fmt.Sprintf("%s %s\n", strings.ToUpper(runtime.GOOS[:1])+runtime.GOOS[1:], machine)}
+	require.NoError(t, runCopy(context.Background(), h, nil, io.Discard, io.Discard))
+	assert.Equal(t, []string{"/tmp/task/act_runner"}, h.copied)
+	assert.Equal(t, []string{"uname -sm", "/tmp/task/act_runner sandbox-worker"}, h.execs)
+
+	other := "arm64"
+	if runtime.GOARCH == "arm64" {
+		other = "amd64"
+	}
+	h = &fakeCopyHost{uname: "Linux " + other + "\n"}
+	err := runCopy(context.Background(), h, nil, io.Discard, io.Discard)
+	assert.EqualError(t, err, fmt.Sprintf("the sandbox runs on linux/%s, but the runner is built for %s/%s", other, runtime.GOOS, runtime.GOARCH))
+	assert.Empty(t, h.copied, "the runner isn't copied to a host which can't run it")
+
+	h = &fakeCopyHost{uname: "Linux\n"}
+	assert.ErrorContains(t, runCopy(context.Background(), h, nil, io.Discard, io.Discard), "cannot tell the platform")
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+// remoteHost is a directory for a task on a host reached by SSH.
+type remoteHost struct {
+	cfg  config.SSH
+	user string
+	addr string // addr is the host and the port
+	name string
+
+	client  *ssh.Client
+	created bool // created is true if the directory has been created by the sandbox, so it may be removed
+}
+
+func newRemoteHost(cfg config.SSH, target, name string) (*remoteHost, error) {
+	u, err := url.Parse("ssh:" + target)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	return &remoteHost{
+		cfg:  cfg,
+		user: u.User.Username(),
+		addr: net.JoinHostPort(u.Hostname(), port),
+		name: name,
+	}, nil
+}
+
+func (h *remoteHost) Dir() string {
+	return "/tmp/" + h.name
+}
+
+// keyFile returns the private key for the host, by "user@host", "host" or the default one.
+func (h *remoteHost) keyFile() string {
+	host, _, _ := net.SplitHostPort(h.addr)
+	for _, k := range []string{h.user + "@" + host, host} {
+		if f, ok := h.cfg.HostKeys[k]; ok {
+			return f
+		}
+	}
+	return h.cfg.KeyFile
+}
+
+func (h *remoteHost) Start(ctx context.Context) error {
+	keyFile := h.keyFile()
+	if keyFile == "" {
+		return errors.New("no private key configured for ssh")
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("parse private key %q: %w", keyFile, err)
+	}
+	hostKeyCallback, err := knownhosts.New(h.cfg.KnownHostsFile)
+	if err != nil {
+		return fmt.Errorf("load known hosts: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: h.cfg.ConnectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", h.addr)
+	if err != nil {
+		return err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, h.addr, &ssh.ClientConfig{
+		User:            h.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         h.cfg.ConnectTimeout,
+	})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connect to %s@%s: %w", h.user, h.addr, err)
+	}
+	h.client = ssh.NewClient(c, chans, reqs)
+
+	// mkdir fails if the directory exists, so it's never one of another sandbox
+	if err := h.Exec(ctx, []string{"mkdir", "-m", "0700", h.Dir()}, nil, nil, nil); err != nil {
+		return err
+	}
+	h.created = true
+	return nil
+}
+
+func (h *remoteHost) CopyFile(ctx context.Context, src, dst string, mode os.FileMode) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// there may be no scp or sftp, but there is a shell
+	script := fmt.Sprintf("mkdir -p %s && cat > %s && chmod %04o %s", shellQuote(path.Dir(dst)), shellQuote(dst), mode.Perm(), shellQuote(dst))
+	return h.Exec(ctx, []string{"sh", "-c", script}, f, nil, nil)
+}
+
+func (h *remoteHost) Exec(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if h.client == nil {
+		return errors.New("not connected")
+	}
+	session, err := h.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	output := &lockedBuffer{}
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if stdout == nil {
+		session.Stdout = output
+	}
+	if stderr == nil {
+		session.Stderr = output
+	}
+
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, shellQuote(a))
+	}
+	if err := session.Start(strings.Join(quoted, " ")); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// the process is killed, or gets SIGPIPE once the session is closed if the server ignores the signal
+		_ = session.Signal(ssh.SIGKILL)
+		session.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+func (h *remoteHost) Run(ctx context.Context, job []byte, stdout, stderr io.Writer) error {
+	return runCopy(ctx, h, job, stdout, stderr)
+}
+
+func (h *remoteHost) Destroy(ctx context.Context) error {
+	if h.client == nil {
+		return nil
+	}
+	defer h.client.Close()
+	if !h.created {
+		return nil
+	}
+	return h.Exec(ctx, []string{"rm", "-rf", h.Dir()}, nil, nil, nil)
+}
+
+// lockedBuffer is a buffer for the output of a session, which copies stdout and stderr concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
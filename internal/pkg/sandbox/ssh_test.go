@@ -0,0 +1,171 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"gitea.com/gitea/act_runner/internal/pkg/config"
+)
+
+// startSSHServer starts a server which runs commands with the local shell, it returns its address and the configuration to reach it.
+func startSSHServer(t *testing.T) (string, config.SSH) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the server runs commands with sh")
+	}
+	dir := t.TempDir()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+	clientPub, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+	authorized, err := ssh.NewPublicKey(clientPub)
+	require.NoError(t, err)
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, serverConfig)
+		}
+	}()
+
+	addr := listener.Addr().String()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHosts, []byte(line+"\n"), 0o600))
+
+	return addr, config.SSH{
+		KeyFile:        keyFile,
+		KnownHostsFile: knownHosts,
+		ConnectTimeout: 10 * time.Second,
+	}
+}
+
+func serveSSH(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				var payload struct{ Command string }
+				if req.Type != "exec" || ssh.Unmarshal(req.Payload, &payload) != nil {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+
+				cmd := exec.Command("sh", "-c", payload.Command)
+				cmd.Stdin = channel
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				status := uint32(0)
+				if err := cmd.Run(); err != nil {
+					status = 1
+				}
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	addr, cfg := startSSHServer(t)
+	name := "gitea-actions-task-test-" + strings.ReplaceAll(t.Name(), "/", "-")
+	h, err := newRemoteHost(cfg, "//ci@"+addr, name)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/"+name, h.Dir())
+
+	ctx := context.Background()
+	require.NoError(t, h.Start(ctx))
+
+	src := filepath.Join(t.TempDir(), "worker")
+	require.NoError(t, os.WriteFile(src, []byte("#!/bin/sh\necho \"it's $1\"\ncat\n"), 0o600))
+	require.NoError(t, h.CopyFile(ctx, src, h.Dir()+"/bin/worker", 0o755))
+
+	var stdout bytes.Buffer
+	require.NoError(t, h.Exec(ctx, []string{h.Dir() + "/bin/worker", "a 'quoted' arg"}, strings.NewReader("input"), &stdout, nil))
+	assert.Equal(t, "it's a 'quoted' arg\ninput", stdout.String())
+
+	assert.ErrorContains(t, h.Exec(ctx, []string{"sh", "-c", "echo failed >&2; exit 3"}, nil, nil, nil), "failed")
+
+	require.NoError(t, h.Destroy(ctx))
+	_, err = os.Stat(h.Dir())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRemoteHost_ExistingDir(t *testing.T) {
+	addr, cfg := startSSHServer(t)
+	name := "gitea-actions-task-test-existing"
+	h, err := newRemoteHost(cfg, "//ci@"+addr, name)
+	require.NoError(t, err)
+
+	// the directory belongs to someone else
+	require.NoError(t, os.Mkdir(h.Dir(), 0o700))
+	t.Cleanup(func() { os.RemoveAll(h.Dir()) })
+	require.NoError(t, os.WriteFile(filepath.Join(h.Dir(), "file"), nil, 0o600))
+
+	ctx := context.Background()
+	assert.ErrorContains(t, h.Start(ctx), "mkdir")
+	require.NoError(t, h.Destroy(ctx))
+	assert.FileExists(t, filepath.Join(h.Dir(), "file"), "a directory not created by the sandbox is kept")
+}
+
+func TestRemoteHost_UnknownHost(t *testing.T) {
+	addr, cfg := startSSHServer(t)
+	require.NoError(t, os.WriteFile(cfg.KnownHostsFile, nil, 0o600))
+
+	h, err := newRemoteHost(cfg, "//ci@"+addr, "gitea-actions-task-unknown")
+	require.NoError(t, err)
+	assert.ErrorContains(t, h.Start(context.Background()), "key is unknown")
+	require.NoError(t, h.Destroy(context.Background()))
+}